@@ -0,0 +1,269 @@
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/schema"
+	"os"
+	"time"
+)
+
+// IndexDef describes where an index lives and how it is tuned. It is read
+// from the index definition file shared by the index, query and server
+// commands.
+type IndexDef struct {
+	Backend              string `json:"backend"`
+	Region               string `json:"region"`
+	Endpoint             string `json:"endpoint"`
+	AccessKey            string `json:"access_key"`
+	SecretKey            string `json:"secret_key"`
+	IndexTable           string `json:"index_table"`
+	SourceTable          string `json:"source_table"`
+	Threshold            int    `json:"threshold"`
+	IndexThroughput      int    `json:"index_throughput"`
+	SourceThroughput     int    `json:"source_throughput"`
+	IndexReadThroughput  int    `json:"index_read_throughput"`
+	SourceReadThroughput int    `json:"source_read_throughput"`
+	Concurrency          int    `json:"concurrency"`
+	MaxBucket            int    `json:"max_bucket"`
+	KeyFormat            int    `json:"key_format"`
+	Probes               int    `json:"probes"`
+	Snapshot             string `json:"snapshot"`
+}
+
+const (
+	DEFAULT_BACKEND           = "dynamodb"
+	DEFAULT_REGION            = "us-east-1"
+	DEFAULT_THRESHOLD         = 64
+	DEFAULT_INDEX_THROUGHPUT  = 5000
+	DEFAULT_SOURCE_THROUGHPUT = 1000
+	DEFAULT_READ_THROUGHPUT   = 5000
+	DEFAULT_CONCURRENCY       = 128
+)
+
+func (d *IndexDef) LoadJSON(data []byte) (err error) {
+	err = json.Unmarshal(data, d)
+	if err != nil {
+		return
+	}
+	d.defaults()
+	return
+}
+
+func (d *IndexDef) defaults() {
+	if d.Backend == "" {
+		d.Backend = DEFAULT_BACKEND
+	}
+	if d.Region == "" {
+		d.Region = DEFAULT_REGION
+	}
+	if d.Threshold == 0 {
+		d.Threshold = DEFAULT_THRESHOLD
+	}
+	if d.IndexThroughput == 0 {
+		d.IndexThroughput = DEFAULT_INDEX_THROUGHPUT
+	}
+	if d.SourceThroughput == 0 {
+		d.SourceThroughput = DEFAULT_SOURCE_THROUGHPUT
+	}
+	if d.IndexReadThroughput == 0 {
+		d.IndexReadThroughput = DEFAULT_READ_THROUGHPUT
+	}
+	if d.SourceReadThroughput == 0 {
+		d.SourceReadThroughput = DEFAULT_READ_THROUGHPUT
+	}
+	if d.Concurrency == 0 {
+		d.Concurrency = DEFAULT_CONCURRENCY
+	}
+}
+
+type Backend struct {
+	Name        string
+	Description string
+	Open        func(*IndexDef, schema.Signer) (schema.Index, error)
+
+	// Validate, if not nil, checks that def can hold the signatures of a
+	// signer before the index is opened.
+	Validate func(*IndexDef, schema.Signer) error
+
+	// Records, if not nil, opens the store of the records written to an
+	// index (see OpenRecords).
+	Records func(*IndexDef) (schema.RecordStore, error)
+}
+
+var Backends = []*Backend{
+	backendDynamoDB,
+	backendMemory,
+}
+
+// Register makes a backend available to Open under b.Name.
+func Register(b *Backend) {
+	Backends = append(Backends, b)
+}
+
+// Validate checks that the index described by def can hold the
+// signatures of s.
+func Validate(def *IndexDef, s schema.Signer) error {
+	def.defaults()
+	for _, b := range Backends {
+		if b.Name == def.Backend {
+			if b.Validate == nil {
+				return nil
+			}
+			return b.Validate(def, s)
+		}
+	}
+	return fmt.Errorf("backend not found: %s", def.Backend)
+}
+
+// Open returns the index described by def.
+func Open(def *IndexDef, s schema.Signer) (schema.Index, error) {
+	def.defaults()
+	for _, b := range Backends {
+		if b.Name == def.Backend {
+			return b.Open(def, s)
+		}
+	}
+	return nil, fmt.Errorf("backend not found: %s", def.Backend)
+}
+
+var backendMemory = &Backend{
+	Name:        "memory",
+	Description: "(snapshot) in-process index, discarded on exit unless saved to a snapshot file",
+	Open:        openMemory,
+	Validate:    validateMemory,
+	Records:     memoryRecords,
+}
+
+// MEMORY_MAX_CHUNK_BITS bounds chunk widths for the memory backend, which
+// allocates a slot for every possible value of every chunk.
+const MEMORY_MAX_CHUNK_BITS = 24
+
+func validateMemory(def *IndexDef, s schema.Signer) error {
+	if s.ChunkBits() > MEMORY_MAX_CHUNK_BITS {
+		return fmt.Errorf("memory backend: chunks of %d bits are too wide, max %d", s.ChunkBits(), MEMORY_MAX_CHUNK_BITS)
+	}
+	return nil
+}
+
+func openMemory(def *IndexDef, s schema.Signer) (schema.Index, error) {
+	err := validateMemory(def, s)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := schema.NewMemoryIndex(s).(*schema.MemoryIndex)
+	ix.SetMaxBucket(def.MaxBucket)
+	ix.SetProbes(def.Probes)
+	if def.Snapshot == "" {
+		return ix, nil
+	}
+
+	mix := &memoryIndex{MemoryIndex: ix, path: def.Snapshot}
+	snapshot, err := loadSnapshot(def.Snapshot)
+	if err == nil {
+		mix.pending = snapshot
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return mix, nil
+}
+
+var backendDynamoDB = &Backend{
+	Name:        "dynamodb",
+	Description: "(region endpoint access_key secret_key index_table source_table) Amazon DynamoDB tables",
+	Open:        openDynamoDB,
+	Validate:    validateDynamoDB,
+	Records:     dynamoRecords,
+}
+
+func validateDynamoDB(def *IndexDef, s schema.Signer) error {
+	if def.IndexTable == "" || def.SourceTable == "" {
+		return fmt.Errorf("index_table and source_table are required")
+	}
+	return KeyFormat(def.KeyFormat).Validate(s)
+}
+
+func openDynamoDB(def *IndexDef, s schema.Signer) (schema.Index, error) {
+	err := validateDynamoDB(def, s)
+	if err != nil {
+		return nil, err
+	}
+
+	indexT, sourceT, err := OpenTables(def)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := newDynamoDBIndex(s, indexT, sourceT, def)
+	return ix, nil
+}
+
+// OpenTables returns the DynamoDB index and source tables named in def.
+func OpenTables(def *IndexDef) (indexT Table, sourceT Table, err error) {
+	server, err := dynamoServer(def)
+	if err != nil {
+		return
+	}
+	t, err := describeTable(server, def.IndexTable)
+	if err != nil {
+		return
+	}
+	indexT = NewDynamoTable(t)
+	t, err = describeTable(server, def.SourceTable)
+	if err != nil {
+		return
+	}
+	sourceT = NewDynamoTable(t)
+	return
+}
+
+func dynamoServer(def *IndexDef) (*dynamodb.Server, error) {
+	region, exists := aws.Regions[def.Region]
+	if !exists {
+		region = aws.Region{Name: def.Region}
+	}
+	if def.Endpoint != "" {
+		region.DynamoDBEndpoint = def.Endpoint
+	}
+	if region.DynamoDBEndpoint == "" {
+		return nil, fmt.Errorf("unknown region %s and no endpoint given", def.Region)
+	}
+
+	auth, err := dynamoAuth(def)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.Server{Auth: auth, Region: region}, nil
+}
+
+// dynamoAuth uses the static credentials from def if any are given (as
+// for DynamoDB Local, which accepts any), and otherwise the environment
+// or instance credentials.
+func dynamoAuth(def *IndexDef) (auth aws.Auth, err error) {
+	expires := time.Now().Add(time.Duration(60) * time.Minute)
+	if def.AccessKey != "" {
+		return aws.GetAuth(def.AccessKey, def.SecretKey, "", expires)
+	}
+
+	auth, err = aws.EnvAuth()
+	if err != nil {
+		auth, err = aws.GetAuth("", "", "", expires)
+	}
+	return
+}
+
+func describeTable(s *dynamodb.Server, name string) (*dynamodb.Table, error) {
+	td, err := s.DescribeTable(name)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := td.BuildPrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+	return s.NewTable(name, pk), nil
+}
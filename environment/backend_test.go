@@ -0,0 +1,68 @@
+package environment
+
+import (
+	"github.com/wsc/phosphorus/schema"
+	"testing"
+)
+
+const INDEXDEF = `{"backend":"memory","threshold":8}`
+
+func TestIndexDefDefaults(t *testing.T) {
+	def := &IndexDef{}
+	err := def.LoadJSON([]byte(INDEXDEF))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if def.Threshold != 8 {
+		t.Fail()
+	}
+	if def.Concurrency != DEFAULT_CONCURRENCY {
+		t.Fail()
+	}
+	if def.Region != DEFAULT_REGION {
+		t.Fail()
+	}
+}
+
+func TestOpen(t *testing.T) {
+	def := &IndexDef{}
+	def.LoadJSON([]byte(INDEXDEF))
+
+	ix, err := Open(def, &_schema{sig1})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := ix.(*schema.MemoryIndex); !ok {
+		t.Errorf("%T", ix)
+	}
+
+	def.Backend = "nonesuch"
+	_, err = Open(def, &_schema{sig1})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestValidate(t *testing.T) {
+	memory := &IndexDef{Backend: "memory"}
+	if err := Validate(memory, &_schema{sig1}); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(memory, &_wide{_schema{sig1}, 4, 32}); err == nil {
+		t.Error("memory backend accepted 32-bit chunks")
+	}
+
+	dynamo := &IndexDef{IndexTable: "index", SourceTable: "source"}
+	if err := Validate(dynamo, &_wide{_schema{sig1}, 4, 32}); err == nil {
+		t.Error("legacy key format accepted 32-bit chunks")
+	}
+	dynamo.KeyFormat = int(KEY_FORMAT_VARINT)
+	if err := Validate(dynamo, &_wide{_schema{sig1}, 4, 32}); err != nil {
+		t.Error(err)
+	}
+	dynamo.IndexTable = ""
+	if err := Validate(dynamo, &_schema{sig1}); err == nil {
+		t.Error("missing table accepted")
+	}
+}
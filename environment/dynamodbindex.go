@@ -2,61 +2,141 @@ package environment
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/ratelimit"
 	"github.com/wsc/phosphorus/schema"
 	"log"
 	"math"
+	"math/rand"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-const SET_ATTR = "ids"
+const (
+	SET_ATTR      = "ids"
+	COUNT_ATTR    = "n"
+	STOP_ATTR     = "stop"
+	OVERFLOW_ATTR = "overflow"
+)
+
+// SHARD_SIZE is the number of IDs written to one item before a bucket
+// spills into an overflow shard. Base64 IDs take 8 bytes each, keeping
+// items well under DynamoDB's 400 KB limit.
+const SHARD_SIZE = 32768
+
+// STRIPES is the number of locks bucket state is spread over. Buckets are
+// kept in maps so that only buckets in use take memory, however wide the
+// schema's chunks.
+const STRIPES = 1024
+
+// bucket is the write state of one signature bucket.
+type bucket struct {
+	ids     map[uint32]bool // buffered, not yet written
+	count   int             // IDs written by this index
+	shard   uint16          // overflow shard being filled
+	fill    int             // IDs written to that shard
+	stopped bool
+}
+
+type stripe struct {
+	sync.Mutex
+	buckets map[schema.Bucket]*bucket
+}
 
 type DynamoDBIndex struct {
-	indexT    *dynamodb.Table
-	sourceT   *dynamodb.Table
-	indexM    *WriteSem
-	sourceM   *WriteSem
+	indexT    Table
+	sourceT   Table
+	indexM    *ratelimit.Limiter
+	sourceM   *ratelimit.Limiter
+	indexR    *ratelimit.Limiter
+	sourceR   *ratelimit.Limiter
 	signer    schema.Signer
-	buckets   [][]map[uint32]bool
-	locks     [][]sync.Mutex
+	keys      KeyFormat
+	stripes   [STRIPES]stripe
+	shardSize int
 	threshold int
+	maxBucket int
+	probes    int
+
+	fingerprint string
+	fpLoaded    bool
+	fpLock      sync.Mutex
+	stamped     sync.Once
+	stampErr    error
+	current     string
+	currentOnce sync.Once
 }
 
-func NewDynamoDBIndex(s schema.Signer, indexT *dynamodb.Table, sourceT *dynamodb.Table) schema.Index {
+func NewDynamoDBIndex(s schema.Signer, indexT Table, sourceT Table) schema.Index {
+	def := &IndexDef{}
+	def.defaults()
+	return newDynamoDBIndex(s, indexT, sourceT, def)
+}
+
+func newDynamoDBIndex(s schema.Signer, indexT Table, sourceT Table, def *IndexDef) *DynamoDBIndex {
 	ix := &DynamoDBIndex{
 		indexT:    indexT,
 		sourceT:   sourceT,
-		indexM:    NewWriteSem(5000),
-		sourceM:   NewWriteSem(1000),
+		indexM:    ratelimit.New(ratelimit.Config{Rate: def.IndexThroughput}),
+		sourceM:   ratelimit.New(ratelimit.Config{Rate: def.SourceThroughput}),
+		indexR:    ratelimit.New(ratelimit.Config{Rate: def.IndexReadThroughput}),
+		sourceR:   ratelimit.New(ratelimit.Config{Rate: def.SourceReadThroughput}),
 		signer:    s,
-		threshold: 64}
-
-	numChunks := s.SignatureLen()
-	sigValues := 1 << uint(s.ChunkBits())
-
-	ix.buckets = make([][]map[uint32]bool, numChunks)
-	ix.locks = make([][]sync.Mutex, numChunks)
-	for i := 0; i < numChunks; i++ {
-		ix.buckets[i] = make([]map[uint32]bool, sigValues)
-		ix.locks[i] = make([]sync.Mutex, sigValues)
+		keys:      KeyFormat(def.KeyFormat),
+		threshold: def.Threshold,
+		shardSize: SHARD_SIZE,
+		maxBucket: def.MaxBucket,
+		probes:    def.Probes}
+
+	for i := range ix.stripes {
+		ix.stripes[i].buckets = make(map[schema.Bucket]*bucket)
 	}
 
 	return ix
 }
 
+func (ix *DynamoDBIndex) stripe(b schema.Bucket) *stripe {
+	h := uint32(b.Chunk)*2654435761 ^ b.Value
+	return &ix.stripes[h%STRIPES]
+}
+
+// bucket returns the state of b. The caller must hold b's stripe lock.
+func (ix *DynamoDBIndex) bucket(st *stripe, b schema.Bucket) *bucket {
+	bk, exists := st.buckets[b]
+	if !exists {
+		bk = &bucket{}
+		st.buckets[b] = bk
+	}
+	return bk
+}
+
 const (
-	DDB_TOOMUCH = "ProvisionedThroughputExceededException"
-	DDB_ISE     = "InternalServerError"
+	DDB_TOOMUCH    = "ProvisionedThroughputExceededException"
+	DDB_ISE        = "InternalServerError"
+	DDB_VALIDATION = "ValidationException"
 )
 
+// itemTooLarge reports whether err is DynamoDB rejecting an update because
+// the item would exceed the maximum item size.
+func itemTooLarge(err error) bool {
+	e, ok := err.(*dynamodb.Error)
+	return ok && e.Code == DDB_VALIDATION && strings.Contains(strings.ToLower(e.Message), "item size")
+}
+
 func (ix *DynamoDBIndex) addAttrsIndex(key *dynamodb.Key, attrs []dynamodb.Attribute) error {
 	for {
-		ix.indexM.Write(1)
-		_, err := ix.indexT.AddAttributes(key, attrs)
+		err := ix.indexM.Wait(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		_, err = ix.indexT.AddAttributes(key, attrs)
 		if err == nil {
 			break
 		}
@@ -75,39 +155,168 @@ func (ix *DynamoDBIndex) addAttrsIndex(key *dynamodb.Key, attrs []dynamodb.Attri
 	return nil
 }
 
-func (ix *DynamoDBIndex) flush(sIdx, sVal int) error {
-	l := len(ix.buckets[sIdx][sVal])
+// flush writes the buffered IDs of a bucket, along with the number of IDs
+// added, which DynamoDB sums into the bucket's COUNT_ATTR. A bucket that
+// would grow past maxBucket is instead marked with STOP_ATTR and receives
+// no further IDs. The caller must hold the bucket's stripe lock.
+func (ix *DynamoDBIndex) flush(b schema.Bucket, bk *bucket) error {
+	l := len(bk.ids)
 	if l == 0 {
 		return nil
 	}
+	key := &dynamodb.Key{ix.keys.Key(b, 0), ""}
+
+	if ix.maxBucket > 0 && bk.count+l > ix.maxBucket {
+		attrs := []dynamodb.Attribute{*dynamodb.NewNumericAttribute(STOP_ATTR, "1")}
+		err := ix.addAttrsIndex(key, attrs)
+		if err != nil {
+			return err
+		}
+		bk.count += l
+		bk.ids = nil
+		bk.stopped = true
+		return nil
+	}
+
 	ids := make([]string, 0, l)
-	for k, _ := range ix.buckets[sIdx][sVal] {
+	for k, _ := range bk.ids {
 		ids = append(ids, uint32ToBase64String(k))
 	}
-	key := &dynamodb.Key{binkey(sIdx, sVal), ""}
-	attrs := []dynamodb.Attribute{*dynamodb.NewBinarySetAttribute(SET_ATTR, ids)}
-	err := ix.addAttrsIndex(key, attrs)
+
+	if bk.fill > 0 && bk.fill+l > ix.shardSize {
+		err := ix.nextShard(b, bk)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		err := ix.writeIds(b, bk, ids)
+		if err == nil {
+			break
+		}
+		if !itemTooLarge(err) {
+			return err
+		}
+
+		// the shard is full: another writer may already have moved on,
+		// otherwise start a new one
+		stored, err := ix.storedShards(b)
+		if err != nil {
+			return err
+		}
+		if stored > bk.shard {
+			bk.shard = stored
+			bk.fill = 0
+			continue
+		}
+		err = ix.nextShard(b, bk)
+		if err != nil {
+			return err
+		}
+	}
+	bk.count += l
+	bk.fill += l
+	bk.ids = nil
+
+	return nil
+}
+
+// writeIds adds ids to the bucket's current shard and their number to the
+// count kept on the bucket's base item.
+func (ix *DynamoDBIndex) writeIds(b schema.Bucket, bk *bucket, ids []string) error {
+	base := &dynamodb.Key{ix.keys.Key(b, 0), ""}
+	set := *dynamodb.NewBinarySetAttribute(SET_ATTR, ids)
+	count := *dynamodb.NewNumericAttribute(COUNT_ATTR, strconv.Itoa(len(ids)))
+
+	if bk.shard == 0 {
+		return ix.addAttrsIndex(base, []dynamodb.Attribute{set, count})
+	}
+
+	key := &dynamodb.Key{ix.keys.Key(b, bk.shard), ""}
+	err := ix.addAttrsIndex(key, []dynamodb.Attribute{set})
 	if err != nil {
 		return err
 	}
-	ix.buckets[sIdx][sVal] = make(map[uint32]bool)
+	return ix.addAttrsIndex(base, []dynamodb.Attribute{count})
+}
 
+// nextShard moves a bucket on to a new overflow shard, recording the
+// number of overflow shards on the base item for readers.
+func (ix *DynamoDBIndex) nextShard(b schema.Bucket, bk *bucket) error {
+	if bk.shard == math.MaxUint16 {
+		return fmt.Errorf("bucket %d/%d: out of overflow shards", b.Chunk, b.Value)
+	}
+	base := &dynamodb.Key{ix.keys.Key(b, 0), ""}
+	attrs := []dynamodb.Attribute{*dynamodb.NewNumericAttribute(OVERFLOW_ATTR, "1")}
+	err := ix.addAttrsIndex(base, attrs)
+	if err != nil {
+		return err
+	}
+	bk.shard++
+	bk.fill = 0
 	return nil
 }
 
-func (ix *DynamoDBIndex) insertId(id uint32, sIdx, sVal int) error {
-	ix.locks[sIdx][sVal].Lock()
-	defer ix.locks[sIdx][sVal].Unlock()
+func (ix *DynamoDBIndex) storedShards(b schema.Bucket) (uint16, error) {
+	keys := []dynamodb.Key{dynamodb.Key{ix.keys.Key(b, 0), ""}}
+	items, err := batchGet(ix.indexT, ix.indexR, keys, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	return overflowShards(items[0]), nil
+}
+
+func overflowShards(item map[string]*dynamodb.Attribute) uint16 {
+	a, exists := item[OVERFLOW_ATTR]
+	if !exists {
+		return 0
+	}
+	n, err := strconv.ParseUint(a.Value, 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(n)
+}
 
-	bucket := ix.buckets[sIdx][sVal]
-	if bucket == nil {
-		ix.buckets[sIdx][sVal] = make(map[uint32]bool)
+// StopBuckets returns the buckets this index has marked as stop buckets
+// while writing.
+func (ix *DynamoDBIndex) StopBuckets() []schema.Bucket {
+	stops := []schema.Bucket{}
+	for i := range ix.stripes {
+		st := &ix.stripes[i]
+		st.Lock()
+		for b, bk := range st.buckets {
+			if bk.stopped {
+				stops = append(stops, b)
+			}
+		}
+		st.Unlock()
 	}
+	sort.Sort(schema.ByBucket(stops))
+	return stops
+}
+
+func (ix *DynamoDBIndex) insertId(id uint32, b schema.Bucket) error {
+	st := ix.stripe(b)
+	st.Lock()
+	defer st.Unlock()
 
-	ix.buckets[sIdx][sVal][id] = true
+	bk := ix.bucket(st, b)
+	if bk.stopped {
+		bk.count++
+		return nil
+	}
 
-	if len(ix.buckets[sIdx][sVal]) >= ix.threshold {
-		err := ix.flush(sIdx, sVal)
+	if bk.ids == nil {
+		bk.ids = make(map[uint32]bool)
+	}
+	bk.ids[id] = true
+
+	if len(bk.ids) >= ix.threshold {
+		err := ix.flush(b, bk)
 		if err != nil {
 			return err
 		}
@@ -123,8 +332,11 @@ func (ix *DynamoDBIndex) insertRecord(id uint32, attrs map[string]string) error
 	}
 
 	for {
-		ix.sourceM.Write(1)
-		_, err := ix.sourceT.PutItem(uint32ToBase64String(id), "", dynamoAttrs)
+		err := ix.sourceM.Wait(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		_, err = ix.sourceT.PutItem(uint32ToBase64String(id), "", dynamoAttrs)
 		if err == nil {
 			break
 		}
@@ -144,6 +356,11 @@ func (ix *DynamoDBIndex) insertRecord(id uint32, attrs map[string]string) error
 }
 
 func (ix *DynamoDBIndex) Write(record *schema.Record, r schema.RandomProvider) error {
+	ix.stamped.Do(func() { ix.stampErr = ix.stamp(r) })
+	if ix.stampErr != nil {
+		return ix.stampErr
+	}
+
 	sigs, err := ix.signer.Sign(record.Attrs, r)
 	if err != nil {
 		return err
@@ -154,7 +371,7 @@ func (ix *DynamoDBIndex) Write(record *schema.Record, r schema.RandomProvider) e
 		return err
 	}
 	for sigIdx, sigVal := range sigs {
-		err := ix.insertId(record.Id, sigIdx, int(sigVal))
+		err := ix.insertId(record.Id, schema.Bucket{sigIdx, sigVal})
 		if err != nil {
 			return err
 		}
@@ -163,25 +380,80 @@ func (ix *DynamoDBIndex) Write(record *schema.Record, r schema.RandomProvider) e
 	return nil
 }
 
-func (ix *DynamoDBIndex) lockAndFlush(sIdx, sVal int) error {
-	ix.locks[sIdx][sVal].Lock()
-	defer ix.locks[sIdx][sVal].Unlock()
-	return ix.flush(sIdx, sVal)
+// Close stops the index's rate limiters. The index must not be used
+// afterwards.
+func (ix *DynamoDBIndex) Close() {
+	ix.indexM.Stop()
+	ix.sourceM.Stop()
+	ix.indexR.Stop()
+	ix.sourceR.Stop()
 }
 
+// Metrics reports the state of the write and read limiters for the index
+// and source tables.
+func (ix *DynamoDBIndex) Metrics() map[string]ratelimit.Metrics {
+	return map[string]ratelimit.Metrics{
+		"index_write":  ix.indexM.Metrics(),
+		"source_write": ix.sourceM.Metrics(),
+		"index_read":   ix.indexR.Metrics(),
+		"source_read":  ix.sourceR.Metrics()}
+}
+
+// Flush writes out every partially filled bucket. A bucket that fails to
+// flush keeps its IDs, so a later Flush retries it; the error reports how
+// many buckets are still pending.
 func (ix *DynamoDBIndex) Flush() error {
-	for sIdx, sVals := range ix.buckets {
-		for sVal, _ := range sVals {
-			err := ix.lockAndFlush(sIdx, sVal)
+	var first error
+	failed := 0
+	for i := range ix.stripes {
+		st := &ix.stripes[i]
+		st.Lock()
+		for b, bk := range st.buckets {
+			err := ix.flush(b, bk)
 			if err != nil {
-				return err
+				if first == nil {
+					first = err
+				}
+				failed++
 			}
 		}
+		st.Unlock()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d buckets not flushed: %s", failed, first)
 	}
 	return nil
 }
 
-func batchGet(table *dynamodb.Table, keys []dynamodb.Key, chunk int) ([]map[string]*dynamodb.Attribute, error) {
+const (
+	READ_RETRIES     = 10
+	READ_BACKOFF_MAX = 5 * time.Second
+)
+
+var readBackoffBase = 50 * time.Millisecond
+
+// readBackoff returns a randomized delay before retry number attempt
+// (exponential backoff with full jitter).
+func readBackoff(attempt int) time.Duration {
+	d := readBackoffBase << uint(attempt)
+	if d <= 0 || d > READ_BACKOFF_MAX {
+		d = READ_BACKOFF_MAX
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func retryable(err error) bool {
+	if e, ok := err.(*dynamodb.Error); ok {
+		return e.Code == DDB_TOOMUCH || e.Code == DDB_ISE
+	}
+	return false
+}
+
+// batchGet fetches keys from table in chunks, throttled by m. Throttled
+// requests and unprocessed keys are retried with backoff; if keys are
+// still unprocessed after READ_RETRIES attempts batchGet fails rather than
+// returning a partial result.
+func batchGet(table Table, m *ratelimit.Limiter, keys []dynamodb.Key, chunk int) ([]map[string]*dynamodb.Attribute, error) {
 	items := []map[string]*dynamodb.Attribute{}
 
 	for i := 0; i < len(keys); i += chunk {
@@ -189,14 +461,40 @@ func batchGet(table *dynamodb.Table, keys []dynamodb.Key, chunk int) ([]map[stri
 		if ub > len(keys) {
 			ub = len(keys)
 		}
-		bgi := table.BatchGetItems(keys[i:ub])
-		results, err := bgi.Execute()
-		if err != nil {
-			return nil, err
-		}
 
-		for _, r := range results[table.Name] {
-			items = append(items, r)
+		pending := keys[i:ub]
+		var lastErr error
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > READ_RETRIES {
+					if lastErr != nil {
+						return nil, lastErr
+					}
+					return nil, fmt.Errorf("%s: %d keys unprocessed after %d retries", table.Name(), len(pending), READ_RETRIES)
+				}
+				time.Sleep(readBackoff(attempt - 1))
+			}
+
+			err := m.Wait(context.Background(), len(pending))
+			if err != nil {
+				return nil, err
+			}
+			results, unprocessed, err := table.BatchGetItems(pending)
+			if err != nil {
+				if retryable(err) {
+					m.Backoff()
+					lastErr = err
+					continue
+				}
+				return nil, err
+			}
+			lastErr = nil
+
+			items = append(items, results...)
+			if len(unprocessed) > 0 {
+				m.Backoff()
+			}
+			pending = unprocessed
 		}
 	}
 	return items, nil
@@ -204,55 +502,108 @@ func batchGet(table *dynamodb.Table, keys []dynamodb.Key, chunk int) ([]map[stri
 
 const INDEX_BATCH_GET_CHUNK = 16
 
-func (ix *DynamoDBIndex) batchGetKeys(sig []uint32) ([]uint32, error) {
-	items, err := batchGet(ix.indexT, dynamokeys(sig), INDEX_BATCH_GET_CHUNK)
+// batchGetKeys returns the IDs in the buckets of probes, skipping stop
+// buckets: those marked by a writer, or holding more than maxBucket IDs.
+func (ix *DynamoDBIndex) batchGetKeys(probes [][]uint32) ([]uint32, error) {
+	items, err := batchGet(ix.indexT, ix.indexR, ix.keys.Keys(probes), INDEX_BATCH_GET_CHUNK)
 	if err != nil {
 		return nil, err
 	}
 
 	recordIds := []uint32{}
+	skipped := 0
+	overflow := []dynamodb.Key{}
+	keyName := ix.indexT.HashKeyName()
 
 	for _, item := range items {
-		for _, setVal := range item[SET_ATTR].SetValues {
-			recordIds = append(recordIds, base64StringToUint32(setVal))
+		if isStopItem(item, ix.maxBucket) {
+			skipped++
+			continue
+		}
+		recordIds = appendIds(recordIds, item)
+
+		if shards := overflowShards(item); shards > 0 {
+			b, _, err := ix.keys.Parse(item[keyName].Value)
+			if err != nil {
+				return nil, err
+			}
+			for shard := uint16(1); shard <= shards; shard++ {
+				overflow = append(overflow, dynamodb.Key{ix.keys.Key(b, shard), ""})
+			}
+		}
+	}
+	if skipped > 0 {
+		log.Printf("query: skipped %d stop buckets\n", skipped)
+	}
+
+	if len(overflow) > 0 {
+		items, err = batchGet(ix.indexT, ix.indexR, overflow, INDEX_BATCH_GET_CHUNK)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			recordIds = appendIds(recordIds, item)
 		}
 	}
 
 	return recordIds, nil
 }
 
+func appendIds(ids []uint32, item map[string]*dynamodb.Attribute) []uint32 {
+	set, exists := item[SET_ATTR]
+	if !exists {
+		return ids
+	}
+	for _, setVal := range set.SetValues {
+		ids = append(ids, base64StringToUint32(setVal))
+	}
+	return ids
+}
+
+func isStopItem(item map[string]*dynamodb.Attribute, maxBucket int) bool {
+	if _, stopped := item[STOP_ATTR]; stopped {
+		return true
+	}
+	if count, exists := item[COUNT_ATTR]; exists && maxBucket > 0 {
+		n, err := strconv.Atoi(count.Value)
+		return err == nil && n > maxBucket
+	}
+	return false
+}
+
 func (ix *DynamoDBIndex) batchGetRecords(ids []uint32) ([]*schema.Record, error) {
-	items, err := batchGet(ix.sourceT, recordkeys(ids), 16)
+	items, err := batchGet(ix.sourceT, ix.sourceR, recordkeys(ids), 16)
 	if err != nil {
 		return nil, err
 	}
 
-	sourceTHashKeyName := ix.sourceT.Key.KeyAttribute.Name
+	sourceTHashKeyName := ix.sourceT.HashKeyName()
 
 	records := make([]*schema.Record, 0, len(ids))
 
 	for _, item := range items {
-		record := &schema.Record{Attrs: make(map[string]string)}
-		for _, attr := range item {
-			if attr.Name == sourceTHashKeyName {
-				record.Id = base64StringToUint32(attr.Value)
-			} else {
-				record.Attrs[attr.Name] = attr.Value
-			}
-		}
-		records = append(records, record)
+		records = append(records, parseRecord(item, sourceTHashKeyName))
 	}
 
 	return records, nil
 }
 
 func (ix *DynamoDBIndex) Query(attrs map[string]string, r schema.RandomProvider) (results []schema.Result, err error) {
-	sigs, err := ix.signer.Sign(attrs, r)
+	stored, err := ix.loadFingerprint()
+	if err != nil {
+		return
+	}
+	err = schema.CheckFingerprint(stored, ix.signerFingerprint(r))
 	if err != nil {
 		return
 	}
 
-	ids, err := ix.batchGetKeys(sigs)
+	probes, err := schema.Probes(ix.signer, attrs, r, ix.probes)
+	if err != nil {
+		return
+	}
+
+	ids, err := ix.batchGetKeys(probes)
 	if err != nil {
 		return
 	}
@@ -280,28 +631,6 @@ func (ix *DynamoDBIndex) Query(attrs map[string]string, r schema.RandomProvider)
 	return
 }
 
-func dynamokeys(sigs []uint32) []dynamodb.Key {
-	keys := make([]dynamodb.Key, len(sigs))
-	for sigIdx, sigVal := range sigs {
-		keys[sigIdx] = dynamodb.Key{binkey(sigIdx, int(sigVal)), ""}
-	}
-	return keys
-}
-
-func binkey(sIdx, sVal int) string {
-	buf := &bytes.Buffer{}
-	err := binary.Write(buf, binary.BigEndian, uint8(sIdx))
-	if err != nil {
-		panic(err)
-	}
-	err = binary.Write(buf, binary.BigEndian, uint16(sVal))
-	if err != nil {
-		panic(err)
-	}
-
-	return base64.StdEncoding.EncodeToString(buf.Bytes())
-}
-
 func recordkeys(ids []uint32) []dynamodb.Key {
 	keys := make([]dynamodb.Key, 0, len(ids))
 	for _, id := range ids {
@@ -325,63 +654,3 @@ func base64StringToUint32(e string) (i uint32) {
 	binary.Read(buf, binary.BigEndian, &i)
 	return
 }
-
-const REPLENISH_INTERVAL = 5000
-
-type WriteSem struct {
-	fill       int
-	dead       bool
-	bucket     int
-	bucketLock sync.Mutex
-	bucketCond *sync.Cond
-	fails      int
-	failsLock  sync.Mutex
-}
-
-func (ws *WriteSem) trickle() {
-	for !ws.dead {
-		ws.bucketLock.Lock()
-		ws.failsLock.Lock()
-
-		if ws.fails > 0 {
-			ws.fill -= (ws.fails / 2)
-		} else if ws.bucket == 0 {
-			f := float64(ws.fill) * 1.1
-			ws.fill = int(math.Ceil(f))
-		}
-		ws.fails = 0
-		ws.bucket |= ws.fill
-		ws.bucketCond.Broadcast()
-		ws.failsLock.Unlock()
-		ws.bucketLock.Unlock()
-		log.Println("New fill: ", ws.fill)
-		time.Sleep(REPLENISH_INTERVAL * time.Millisecond)
-	}
-}
-
-func (ws *WriteSem) Write(n int) {
-	ws.bucketLock.Lock()
-	for ws.bucket < n {
-		ws.bucketCond.Wait()
-	}
-	ws.bucket -= n
-	ws.bucketLock.Unlock()
-}
-
-func (ws *WriteSem) Backoff() {
-	ws.failsLock.Lock()
-	ws.fails++
-	ws.failsLock.Unlock()
-}
-
-func (ws *WriteSem) Kill() {
-	ws.dead = true
-}
-
-func NewWriteSem(throughput int) *WriteSem {
-	ws := &WriteSem{
-		fill: throughput}
-	ws.bucketCond = sync.NewCond(&ws.bucketLock)
-	go ws.trickle()
-	return ws
-}
@@ -3,29 +3,29 @@ package environment
 import (
 	crand "crypto/rand"
 	"fmt"
-	"github.com/crowdmob/goamz/aws"
 	"github.com/crowdmob/goamz/dynamodb"
-	s3_ "github.com/crowdmob/goamz/s3"
-	"github.com/crowdmob/goamz/s3/s3test"
 	"github.com/wsc/phosphorus/schema"
 	"math"
 	"math/big"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 )
 
-var region aws.Region
+// When PHOSPHORUS_DYNAMODB_ENDPOINT is set (e.g. to a DynamoDB Local at
+// http://localhost:8000) the tests run against real tables created there;
+// otherwise they use FakeTable.
+const ENDPOINT_ENV = "PHOSPHORUS_DYNAMODB_ENDPOINT"
+
 var dynamo *dynamodb.Server
-var s3server *s3_.S3
-var token *aws.Auth
 
 var sig1 = []uint32{14, 255, 104, 172, 138, 51, 132, 248}
 var sig2 = []uint32{14, 255, 104, 172, 138, 51, 232, 177}
 var sig3 = []uint32{14, 255, 104, 197, 20, 149, 132, 62}
 
-var rec1 = &schema.Record{1, map[string]string{"first": "John", "last": "Doe"}}
-var rec2 = &schema.Record{2, map[string]string{"first": "Jane", "last": "Roe"}}
+var rec1 = &schema.Record{Id: 1, Attrs: map[string]string{"first": "John", "last": "Doe"}}
+var rec2 = &schema.Record{Id: 2, Attrs: map[string]string{"first": "Jane", "last": "Roe"}}
 
 type _schema struct {
 	fixture []uint32
@@ -76,28 +76,22 @@ func seedRandom() {
 
 func init() {
 	seedRandom()
-	now := time.Now()
-	expires := now.Add(time.Duration(60) * time.Minute)
 
-	token, err := aws.GetAuth(randomString(), "secret", "", expires)
-	if err != nil {
-		panic(err)
+	endpoint := os.Getenv(ENDPOINT_ENV)
+	if endpoint == "" {
+		return
 	}
 
-	s3testserver, err := s3test.NewServer(&s3test.Config{})
+	def := &IndexDef{
+		Region:    "test",
+		Endpoint:  endpoint,
+		AccessKey: randomString(),
+		SecretKey: "secret"}
+	var err error
+	dynamo, err = dynamoServer(def)
 	if err != nil {
 		panic(err)
 	}
-
-	region = aws.Region{
-		Name:                 "test",
-		S3Endpoint:           s3testserver.URL(),
-		S3LocationConstraint: true,
-		DynamoDBEndpoint:     "http://localhost:8000",
-	}
-
-	dynamo = &dynamodb.Server{token, region}
-	s3server = s3_.New(token, region)
 }
 
 func createTable() string {
@@ -122,10 +116,6 @@ func TestBinkey(t *testing.T) {
 }
 
 func TestDynamoDBIndex(t *testing.T) {
-	// if testing.Short() {
-	// 	t.Skip()
-	// }
-
 	s := &_schema{sig1}
 	sourceT := getRandomTable()
 	indexT := getRandomTable()
@@ -163,20 +153,197 @@ func TestDynamoDBIndex(t *testing.T) {
 	}
 }
 
-func getTable(server *dynamodb.Server, name string) *dynamodb.Table {
-	td, err := server.DescribeTable(name)
+func getTable(server *dynamodb.Server, name string) Table {
+	t, err := describeTable(server, name)
 	if err != nil {
 		panic(err)
 	}
+	return NewDynamoTable(t)
+}
+
+func getRandomTable() Table {
+	if dynamo == nil {
+		return NewFakeTable(randomString(), "k")
+	}
+	return getTable(dynamo, createTable())
+}
+
+func TestDynamoDBIndexReadRetries(t *testing.T) {
+	s := &_schema{sig1}
+	sourceT := NewFakeTable(randomString(), "k")
+	indexT := NewFakeTable(randomString(), "k")
+	ix := NewDynamoDBIndex(s, indexT, sourceT)
+	r := &_random{}
 
-	pk, err := td.BuildPrimaryKey()
+	ix.Write(rec1, r)
+	s.fixture = sig2
+	ix.Write(rec2, r)
+	ix.Flush()
+
+	indexT.BatchLimit = 3
+	indexT.Throttle = 2
+	sourceT.BatchLimit = 1
+
+	s.fixture = sig3
+	results, err := ix.Query(map[string]string{}, r)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d results", len(results))
 	}
+	if results[0].Matches != 4 || results[1].Matches != 3 {
+		t.Errorf("%d %d", results[0].Matches, results[1].Matches)
+	}
+}
+
+func TestDynamoDBIndexReadRetriesExhausted(t *testing.T) {
+	defer func(d time.Duration) { readBackoffBase = d }(readBackoffBase)
+	readBackoffBase = time.Millisecond
 
-	return server.NewTable(name, pk)
+	indexT := NewFakeTable(randomString(), "k")
+	indexT.Throttle = READ_RETRIES + 1
+	ix := NewDynamoDBIndex(&_schema{sig1}, indexT, NewFakeTable(randomString(), "k"))
+
+	_, err := ix.Query(map[string]string{}, &_random{})
+	if err == nil {
+		t.Fail()
+	}
 }
 
-func getRandomTable() *dynamodb.Table {
-	return getTable(dynamo, createTable())
+func TestDynamoDBIndexStopBuckets(t *testing.T) {
+	s := &_schema{sig1}
+	def := &IndexDef{Threshold: 1, MaxBucket: 1}
+	def.defaults()
+	indexT := NewFakeTable(randomString(), "k")
+	ix := newDynamoDBIndex(s, indexT, NewFakeTable(randomString(), "k"), def)
+	r := &_random{}
+
+	ix.Write(rec1, r)
+	s.fixture = sig2
+	ix.Write(rec2, r)
+	ix.Flush()
+
+	stops := ix.StopBuckets()
+	if len(stops) != 6 {
+		t.Fatalf("%v", stops)
+	}
+
+	s.fixture = sig3
+	results, err := ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Record.Id != 1 || results[0].Matches != 1 {
+		t.Errorf("%v", results)
+	}
+
+	// readers also skip buckets whose count exceeds their own limit
+	item := map[string]*dynamodb.Attribute{
+		COUNT_ATTR: dynamodb.NewNumericAttribute(COUNT_ATTR, "3")}
+	if !isStopItem(item, 2) || isStopItem(item, 3) || isStopItem(item, 0) {
+		t.Fail()
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	legacy := KEY_FORMAT_LEGACY.Key(schema.Bucket{255, 65535}, 0)
+	if legacy != binkey(255, 65535) {
+		t.Errorf("%q", legacy)
+	}
+
+	for _, f := range []KeyFormat{KEY_FORMAT_LEGACY, KEY_FORMAT_VARINT} {
+		for _, shard := range []uint16{0, 7} {
+			b := schema.Bucket{3, 1000}
+			actual, actualShard, err := f.Parse(f.Key(b, shard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != b || actualShard != shard {
+				t.Errorf("format %d: %v/%d", f, actual, actualShard)
+			}
+		}
+	}
+
+	b := schema.Bucket{1000, 1 << 30}
+	actual, _, err := KEY_FORMAT_VARINT.Parse(KEY_FORMAT_VARINT.Key(b, 0))
+	if err != nil || actual != b {
+		t.Errorf("%v %v", actual, err)
+	}
+}
+
+type _wide struct {
+	_schema
+	chunks, bits int
+}
+
+func (s *_wide) SignatureLen() int {
+	return s.chunks
+}
+
+func (s *_wide) ChunkBits() int {
+	return s.bits
+}
+
+func TestKeyFormatValidate(t *testing.T) {
+	if KEY_FORMAT_LEGACY.Validate(&_schema{}) != nil {
+		t.Fail()
+	}
+	if KEY_FORMAT_LEGACY.Validate(&_wide{chunks: 300, bits: 8}) == nil {
+		t.Fail()
+	}
+	if KEY_FORMAT_LEGACY.Validate(&_wide{chunks: 8, bits: 20}) == nil {
+		t.Fail()
+	}
+	if KEY_FORMAT_VARINT.Validate(&_wide{chunks: 300, bits: 20}) != nil {
+		t.Fail()
+	}
+	if KeyFormat(9).Validate(&_schema{}) == nil {
+		t.Fail()
+	}
+}
+
+func TestDynamoDBIndexOverflow(t *testing.T) {
+	s := &_schema{sig1}
+	def := &IndexDef{Threshold: 1}
+	def.defaults()
+	indexT := NewFakeTable(randomString(), "k")
+	sourceT := NewFakeTable(randomString(), "k")
+	ix := newDynamoDBIndex(s, indexT, sourceT, def)
+	ix.shardSize = 2
+	r := &_random{}
+
+	// proactive spills after shardSize IDs, and spills forced by the
+	// item size limit from a writer that lost track of the shards
+	indexT.MaxSetSize = 3
+	for id := uint32(1); id <= 5; id++ {
+		err := ix.Write(&schema.Record{Id: id, Attrs: map[string]string{}}, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix.Flush()
+
+	resumed := newDynamoDBIndex(s, indexT, sourceT, def)
+	resumed.shardSize = 10
+	for id := uint32(6); id <= 9; id++ {
+		err := resumed.Write(&schema.Record{Id: id, Attrs: map[string]string{}}, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resumed.Flush()
+
+	results, err := ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 9 {
+		t.Fatalf("%d results", len(results))
+	}
+	for _, result := range results {
+		if result.Matches != 8 {
+			t.Errorf("%d: %d matches", result.Record.Id, result.Matches)
+		}
+	}
 }
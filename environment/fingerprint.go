@@ -0,0 +1,82 @@
+package environment
+
+import (
+	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/schema"
+)
+
+// META_KEY is the key of the index table item holding the index's
+// metadata: META_KEY_BYTE alone, base64 encoded like every key of the
+// binary hash key. Legacy bucket keys are 3 or 5 bytes long and varint
+// keys at least 3, so it can't collide with a bucket key of either format.
+const META_KEY = "/w=="
+
+// META_KEY_BYTE is reserved as a key version byte for the metadata item.
+const META_KEY_BYTE = 0xff
+
+const FINGERPRINT_ATTR = "fingerprint"
+
+// signerFingerprint returns the fingerprint of the index's signer with the
+// random store it is first used with. Computing it reads the random store
+// and every transform argument, so it is done once.
+func (ix *DynamoDBIndex) signerFingerprint(r schema.RandomProvider) string {
+	ix.currentOnce.Do(func() { ix.current = schema.SignerFingerprint(ix.signer, r) })
+	return ix.current
+}
+
+// loadFingerprint returns the fingerprint stored in the index table, or ""
+// if the index predates fingerprints or is empty. It is read only once.
+func (ix *DynamoDBIndex) loadFingerprint() (string, error) {
+	ix.fpLock.Lock()
+	defer ix.fpLock.Unlock()
+	if ix.fpLoaded {
+		return ix.fingerprint, nil
+	}
+
+	items, err := batchGet(ix.indexT, ix.indexR, []dynamodb.Key{{META_KEY, ""}}, 1)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range items {
+		if fp, exists := item[FINGERPRINT_ATTR]; exists {
+			ix.fingerprint = fp.Value
+		}
+	}
+	ix.fpLoaded = true
+	return ix.fingerprint, nil
+}
+
+// stamp stores the fingerprint of the index's signer with r, unless the
+// index already has one, and refuses to write into an index built with a
+// different fingerprint.
+func (ix *DynamoDBIndex) stamp(r schema.RandomProvider) error {
+	current := ix.signerFingerprint(r)
+	stored, err := ix.loadFingerprint()
+	if err != nil {
+		return err
+	}
+	if err := schema.CheckFingerprint(stored, current); err != nil {
+		return err
+	}
+	if stored != "" || current == "" {
+		return nil
+	}
+
+	ix.fpLock.Lock()
+	defer ix.fpLock.Unlock()
+	if ix.fingerprint != "" {
+		return nil
+	}
+	attrs := []dynamodb.Attribute{*dynamodb.NewStringAttribute(FINGERPRINT_ATTR, current)}
+	err = putItem(ix.indexT, ix.indexM, META_KEY, attrs)
+	if err != nil {
+		return err
+	}
+	ix.fingerprint = current
+	return nil
+}
+
+// Fingerprint returns the fingerprint stored in the index table.
+func (ix *DynamoDBIndex) Fingerprint() (string, error) {
+	return ix.loadFingerprint()
+}
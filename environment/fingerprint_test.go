@@ -0,0 +1,74 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/wsc/phosphorus/schema"
+	"testing"
+)
+
+type _fingerprinted struct {
+	_schema
+	fingerprint string
+}
+
+func (s *_fingerprinted) Fingerprint(schema.RandomProvider) string {
+	return s.fingerprint
+}
+
+func TestDynamoDBIndexFingerprint(t *testing.T) {
+	indexT := NewFakeTable(randomString(), "k")
+	sourceT := NewFakeTable(randomString(), "k")
+	def := &IndexDef{}
+	def.defaults()
+	r := &_random{}
+
+	s := &_fingerprinted{_schema{sig1}, "a"}
+	ix := newDynamoDBIndex(s, indexT, sourceT, def)
+	if err := ix.Write(rec1, r); err != nil {
+		t.Fatal(err)
+	}
+	ix.Flush()
+
+	if fp, err := ix.Fingerprint(); err != nil || fp != "a" {
+		t.Errorf("%q %v", fp, err)
+	}
+	if _, err := ix.Query(map[string]string{}, r); err != nil {
+		t.Error(err)
+	}
+
+	// another process, with a different schema or random store
+	other := newDynamoDBIndex(&_fingerprinted{_schema{sig1}, "b"}, indexT, sourceT, def)
+	if _, err := other.Query(map[string]string{}, r); err == nil {
+		t.Error("queried with a different fingerprint")
+	}
+	if err := other.Write(rec2, r); err == nil {
+		t.Error("wrote with a different fingerprint")
+	}
+
+	// signers without a fingerprint can't be checked
+	legacy := newDynamoDBIndex(&_schema{sig1}, indexT, sourceT, def)
+	if _, err := legacy.Query(map[string]string{}, r); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMetaKey(t *testing.T) {
+	raw, err := base64.StdEncoding.DecodeString(META_KEY)
+	if err != nil || !bytes.Equal(raw, []byte{META_KEY_BYTE}) {
+		t.Fatalf("META_KEY %q: %v %v", META_KEY, raw, err)
+	}
+	for _, f := range []KeyFormat{KEY_FORMAT_LEGACY, KEY_FORMAT_VARINT} {
+		if b, shard, err := f.Parse(META_KEY); err == nil {
+			t.Errorf("format %d: META_KEY parses as %v shard %d", f, b, shard)
+		}
+	}
+
+	indexT := NewFakeTable(randomString(), "k")
+	if _, err := indexT.PutItem(META_KEY, "", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := indexT.PutItem("_meta", "", nil); err == nil {
+		t.Error("fake table accepted a key that isn't base64")
+	}
+}
@@ -0,0 +1,148 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/schema"
+)
+
+// KeyFormat is the encoding of bucket keys in the index table. A table is
+// written in a single format, named by key_format in its index definition.
+type KeyFormat int
+
+const (
+	// KEY_FORMAT_LEGACY packs the chunk index into a uint8 and the chunk
+	// value into a uint16, followed by a uint16 overflow shard number if
+	// the shard is not 0. It limits schemas to 256 chunks of 16 bits.
+	KEY_FORMAT_LEGACY KeyFormat = 0
+
+	// KEY_FORMAT_VARINT is a version byte followed by the chunk index,
+	// the chunk value and, if not 0, the overflow shard number, each as
+	// a uvarint. It fits any schema.
+	KEY_FORMAT_VARINT KeyFormat = 1
+)
+
+const (
+	LEGACY_MAX_CHUNKS     = 1 << 8
+	LEGACY_MAX_CHUNK_BITS = 16
+)
+
+// Validate reports whether every bucket of s can be encoded in format f.
+func (f KeyFormat) Validate(s schema.Signer) error {
+	switch f {
+	case KEY_FORMAT_LEGACY:
+		if s.SignatureLen() > LEGACY_MAX_CHUNKS {
+			return fmt.Errorf("key format %d allows at most %d chunks, schema has %d (use key_format %d)",
+				f, LEGACY_MAX_CHUNKS, s.SignatureLen(), KEY_FORMAT_VARINT)
+		}
+		if s.ChunkBits() > LEGACY_MAX_CHUNK_BITS {
+			return fmt.Errorf("key format %d allows chunks of at most %d bits, schema has %d (use key_format %d)",
+				f, LEGACY_MAX_CHUNK_BITS, s.ChunkBits(), KEY_FORMAT_VARINT)
+		}
+	case KEY_FORMAT_VARINT:
+		if s.ChunkBits() > schema.MAX_CHUNK_BITS {
+			return fmt.Errorf("chunks of at most %d bits are supported, schema has %d", schema.MAX_CHUNK_BITS, s.ChunkBits())
+		}
+	default:
+		return fmt.Errorf("unknown key format: %d", f)
+	}
+	return nil
+}
+
+// Key returns the key of shard shard of bucket b; shard 0 is the bucket's
+// base item.
+func (f KeyFormat) Key(b schema.Bucket, shard uint16) string {
+	if f == KEY_FORMAT_LEGACY {
+		if shard == 0 {
+			return binkey(b.Chunk, int(b.Value))
+		}
+		buf := bytes.NewBuffer(rawkey(b.Chunk, int(b.Value)))
+		binary.Write(buf, binary.BigEndian, shard)
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	buf := make([]byte, 1+3*binary.MaxVarintLen64)
+	buf[0] = byte(f)
+	n := 1
+	n += binary.PutUvarint(buf[n:], uint64(b.Chunk))
+	n += binary.PutUvarint(buf[n:], uint64(b.Value))
+	if shard > 0 {
+		n += binary.PutUvarint(buf[n:], uint64(shard))
+	}
+	return base64.StdEncoding.EncodeToString(buf[:n])
+}
+
+// Keys returns the base item keys of the buckets to probe, given as the
+// values to look up for each chunk (see schema.Probes).
+func (f KeyFormat) Keys(probes [][]uint32) []dynamodb.Key {
+	keys := make([]dynamodb.Key, 0, len(probes))
+	for sigIdx, values := range probes {
+		for _, sigVal := range values {
+			keys = append(keys, dynamodb.Key{f.Key(schema.Bucket{sigIdx, sigVal}, 0), ""})
+		}
+	}
+	return keys
+}
+
+// Parse is the inverse of Key.
+func (f KeyFormat) Parse(k string) (b schema.Bucket, shard uint16, err error) {
+	raw, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return
+	}
+
+	if f == KEY_FORMAT_LEGACY {
+		if len(raw) != 3 && len(raw) != 5 {
+			err = fmt.Errorf("invalid bucket key: %q", k)
+			return
+		}
+		b.Chunk = int(raw[0])
+		b.Value = uint32(binary.BigEndian.Uint16(raw[1:3]))
+		if len(raw) == 5 {
+			shard = binary.BigEndian.Uint16(raw[3:5])
+		}
+		return
+	}
+
+	if len(raw) == 0 || KeyFormat(raw[0]) != f {
+		err = fmt.Errorf("invalid bucket key for format %d: %q", f, k)
+		return
+	}
+	r := bytes.NewReader(raw[1:])
+	chunk, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	value, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	b = schema.Bucket{int(chunk), uint32(value)}
+	if r.Len() > 0 {
+		var s uint64
+		s, err = binary.ReadUvarint(r)
+		shard = uint16(s)
+	}
+	return
+}
+
+// binkey is the legacy key of a bucket's base item.
+func binkey(sIdx, sVal int) string {
+	return base64.StdEncoding.EncodeToString(rawkey(sIdx, sVal))
+}
+
+func rawkey(sIdx, sVal int) []byte {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.BigEndian, uint8(sIdx))
+	if err != nil {
+		panic(err)
+	}
+	err = binary.Write(buf, binary.BigEndian, uint16(sVal))
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
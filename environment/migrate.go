@@ -0,0 +1,82 @@
+package environment
+
+import (
+	"context"
+	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/ratelimit"
+	"time"
+)
+
+// MigrateKeys copies every bucket item of the index table from, whose keys
+// are in format fromF, to the index table to, rewriting the keys in format
+// toF. Items are written with PutItem, so an interrupted migration can
+// simply be run again. The source table of an index is keyed by record ID
+// and needs no migration; the metadata item is copied as is. If progress
+// is not nil, it is called with the number of items copied after each page.
+func MigrateKeys(from Table, fromF KeyFormat, to Table, toF KeyFormat, m *ratelimit.Limiter, progress func(int)) error {
+	fromKey := from.HashKeyName()
+	n := 0
+
+	var start *dynamodb.Key
+	for {
+		items, last, err := from.Scan(start)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			attrs := make([]dynamodb.Attribute, 0, len(item))
+			for name, a := range item {
+				if name != fromKey {
+					attrs = append(attrs, *a)
+				}
+			}
+
+			key := item[fromKey].Value
+			if key != META_KEY {
+				b, shard, err := fromF.Parse(key)
+				if err != nil {
+					return err
+				}
+				key = toF.Key(b, shard)
+			}
+
+			err = putItem(to, m, key, attrs)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+
+		if progress != nil {
+			progress(n)
+		}
+		if last == nil {
+			return nil
+		}
+		start = last
+	}
+}
+
+func putItem(t Table, m *ratelimit.Limiter, key string, attrs []dynamodb.Attribute) error {
+	for {
+		err := m.Wait(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		_, err = t.PutItem(key, "", attrs)
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(*dynamodb.Error); ok {
+			if e.Code == DDB_TOOMUCH {
+				m.Backoff()
+				continue
+			} else if e.Code == DDB_ISE {
+				time.Sleep(1000 * time.Millisecond)
+				continue
+			}
+		}
+		return err
+	}
+}
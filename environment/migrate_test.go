@@ -0,0 +1,45 @@
+package environment
+
+import (
+	"github.com/wsc/phosphorus/ratelimit"
+	"github.com/wsc/phosphorus/schema"
+	"testing"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	s := &_schema{sig1}
+	def := &IndexDef{Threshold: 1}
+	def.defaults()
+	indexT := NewFakeTable(randomString(), "k")
+	sourceT := NewFakeTable(randomString(), "k")
+	ix := newDynamoDBIndex(s, indexT, sourceT, def)
+	ix.shardSize = 2
+	r := &_random{}
+
+	for id := uint32(1); id <= 5; id++ {
+		ix.Write(&schema.Record{Id: id, Attrs: map[string]string{}}, r)
+	}
+	ix.Flush()
+
+	migratedT := NewFakeTable(randomString(), "k")
+	m := ratelimit.New(ratelimit.Config{Rate: 1000})
+	defer m.Stop()
+	pages := 0
+	err := MigrateKeys(indexT, KEY_FORMAT_LEGACY, migratedT, KEY_FORMAT_VARINT, m, func(int) { pages++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migratedT.Len() != indexT.Len() || pages == 0 {
+		t.Errorf("%d items, %d pages", migratedT.Len(), pages)
+	}
+
+	def.KeyFormat = int(KEY_FORMAT_VARINT)
+	migrated := newDynamoDBIndex(s, migratedT, sourceT, def)
+	results, err := migrated.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || results[0].Matches != 8 {
+		t.Errorf("%v", results)
+	}
+}
@@ -0,0 +1,199 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"github.com/crowdmob/goamz/dynamodb"
+	"github.com/wsc/phosphorus/ratelimit"
+	"github.com/wsc/phosphorus/schema"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OpenRecords returns the store of the records written to the index
+// described by def.
+func OpenRecords(def *IndexDef) (schema.RecordStore, error) {
+	def.defaults()
+	for _, b := range Backends {
+		if b.Name == def.Backend {
+			if b.Records == nil {
+				return nil, fmt.Errorf("backend %s doesn't store records", b.Name)
+			}
+			return b.Records(def)
+		}
+	}
+	return nil, fmt.Errorf("backend not found: %s", def.Backend)
+}
+
+// memoryIndex is a MemoryIndex saved to a snapshot file on Flush. The
+// records of an existing snapshot are written to the index before its
+// first use, since signing them takes the random store.
+type memoryIndex struct {
+	*schema.MemoryIndex
+	path    string
+	pending *schema.Snapshot
+	once    sync.Once
+	loaded  bool
+	loadErr error
+	lock    sync.Mutex
+}
+
+func (ix *memoryIndex) load(r schema.RandomProvider) error {
+	ix.once.Do(func() {
+		if ix.pending != nil {
+			for id, attrs := range ix.pending.Attrs {
+				err := ix.MemoryIndex.Write(&schema.Record{Id: id, Attrs: attrs}, r)
+				if err != nil {
+					ix.loadErr = fmt.Errorf("%s: record %d: %s", ix.path, id, err)
+					return
+				}
+			}
+		}
+		ix.lock.Lock()
+		ix.loaded = true
+		ix.lock.Unlock()
+	})
+	return ix.loadErr
+}
+
+func (ix *memoryIndex) Write(record *schema.Record, r schema.RandomProvider) error {
+	if err := ix.load(r); err != nil {
+		return err
+	}
+	return ix.MemoryIndex.Write(record, r)
+}
+
+func (ix *memoryIndex) Query(record map[string]string, r schema.RandomProvider) ([]schema.Result, error) {
+	if err := ix.load(r); err != nil {
+		return nil, err
+	}
+	return ix.MemoryIndex.Query(record, r)
+}
+
+// Flush saves the snapshot, unless the index hasn't been used since the
+// snapshot was read.
+func (ix *memoryIndex) Flush() error {
+	ix.lock.Lock()
+	defer ix.lock.Unlock()
+	if !ix.loaded {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(ix.path), filepath.Base(ix.path)+".")
+	if err != nil {
+		return err
+	}
+	err = ix.SaveSnapshot(tmp)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), ix.path)
+}
+
+func loadSnapshot(path string) (*schema.Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return schema.LoadSnapshot(file)
+}
+
+func memoryRecords(def *IndexDef) (schema.RecordStore, error) {
+	if def.Snapshot == "" {
+		return nil, fmt.Errorf("a memory index without snapshot has no records")
+	}
+	return loadSnapshot(def.Snapshot)
+}
+
+func dynamoRecords(def *IndexDef) (schema.RecordStore, error) {
+	_, sourceT, err := OpenTables(def)
+	if err != nil {
+		return nil, err
+	}
+	return NewSourceRecords(sourceT, ratelimit.New(ratelimit.Config{Rate: def.SourceReadThroughput})), nil
+}
+
+// SourceRecords reads the records of a DynamoDB index back from its source
+// table, in scan order.
+type SourceRecords struct {
+	t Table
+	m *ratelimit.Limiter
+}
+
+func NewSourceRecords(t Table, m *ratelimit.Limiter) *SourceRecords {
+	return &SourceRecords{t, m}
+}
+
+func (s *SourceRecords) Records(skip int, c chan<- *schema.Record) error {
+	defer close(c)
+	keyName := s.t.HashKeyName()
+
+	n := 0
+	var start *dynamodb.Key
+	for {
+		items, last, err := s.scan(start)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			n++
+			if n <= skip {
+				continue
+			}
+			c <- parseRecord(item, keyName)
+		}
+
+		if last == nil {
+			return nil
+		}
+		start = last
+	}
+}
+
+// scan reads a page of the table, retrying throttled reads with backoff.
+// Read capacity is paid for after the fact, one unit per item.
+func (s *SourceRecords) scan(start *dynamodb.Key) (items []map[string]*dynamodb.Attribute, last *dynamodb.Key, err error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if attempt > READ_RETRIES {
+				return
+			}
+			time.Sleep(readBackoff(attempt - 1))
+		}
+
+		items, last, err = s.t.Scan(start)
+		if err != nil {
+			if retryable(err) {
+				s.m.Backoff()
+				continue
+			}
+			return
+		}
+		err = s.m.Wait(context.Background(), len(items))
+		return
+	}
+}
+
+// parseRecord returns the record held by a source table item.
+func parseRecord(item map[string]*dynamodb.Attribute, keyName string) *schema.Record {
+	record := &schema.Record{Attrs: make(map[string]string)}
+	for _, attr := range item {
+		if attr.Name == keyName {
+			record.Id = base64StringToUint32(attr.Value)
+		} else {
+			record.Attrs[attr.Name] = attr.Value
+		}
+	}
+	return record
+}
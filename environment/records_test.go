@@ -0,0 +1,102 @@
+package environment
+
+import (
+	"github.com/wsc/phosphorus/ratelimit"
+	"github.com/wsc/phosphorus/schema"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRecords(store schema.RecordStore, skip int) ([]*schema.Record, error) {
+	c := make(chan *schema.Record)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- store.Records(skip, c)
+	}()
+
+	var records []*schema.Record
+	for record := range c {
+		records = append(records, record)
+	}
+	return records, <-errs
+}
+
+func TestSourceRecords(t *testing.T) {
+	sourceT := NewFakeTable(randomString(), "k")
+	def := &IndexDef{}
+	def.defaults()
+	ix := newDynamoDBIndex(&_schema{sig1}, NewFakeTable(randomString(), "k"), sourceT, def)
+	r := &_random{}
+
+	for i := 0; i < 250; i++ {
+		err := ix.Write(&schema.Record{Id: uint32(i), Attrs: map[string]string{"n": string(rune('a' + i%26))}}, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix.Flush()
+
+	store := NewSourceRecords(sourceT, ratelimit.New(ratelimit.Config{Rate: 1000}))
+	all, err := readRecords(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 250 {
+		t.Fatalf("%d records", len(all))
+	}
+	if all[0].Attrs["n"] != string(rune('a'+all[0].Id%26)) {
+		t.Errorf("%v", all[0])
+	}
+
+	rest, err := readRecords(store, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 130 || rest[0].Id != all[120].Id {
+		t.Errorf("resumed at %v, want %v", rest[0], all[120])
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	def := &IndexDef{Backend: "memory", Snapshot: filepath.Join(dir, "index.snapshot")}
+	r := &_random{}
+
+	ix, err := Open(def, &_schema{sig1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.Write(rec1, r)
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new process finds the records of the snapshot
+	ix, err = Open(def, &_schema{sig1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Record.Id != rec1.Id {
+		t.Errorf("%v", results)
+	}
+
+	store, err := OpenRecords(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := readRecords(store, 0)
+	if err != nil || len(records) != 1 {
+		t.Errorf("%v %v", records, err)
+	}
+}
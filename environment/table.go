@@ -0,0 +1,399 @@
+package environment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/dynamodb"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Table is the subset of DynamoDB table operations used by DynamoDBIndex.
+type Table interface {
+	Name() string
+	HashKeyName() string
+	PutItem(hashKey string, rangeKey string, attrs []dynamodb.Attribute) (bool, error)
+	AddAttributes(key *dynamodb.Key, attrs []dynamodb.Attribute) (bool, error)
+	// BatchGetItems returns the items found for keys, and the keys that
+	// DynamoDB left unprocessed and should be requested again.
+	BatchGetItems(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []dynamodb.Key, error)
+
+	// Scan returns a page of items following start, or from the beginning
+	// of the table if start is nil, and the key to continue from, which is
+	// nil after the last page.
+	Scan(start *dynamodb.Key) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, error)
+}
+
+type dynamoTable struct {
+	t *dynamodb.Table
+}
+
+// NewDynamoTable adapts a goamz table to Table.
+func NewDynamoTable(t *dynamodb.Table) Table {
+	return &dynamoTable{t}
+}
+
+func (t *dynamoTable) Name() string {
+	return t.t.Name
+}
+
+func (t *dynamoTable) HashKeyName() string {
+	return t.t.Key.KeyAttribute.Name
+}
+
+func (t *dynamoTable) PutItem(hashKey string, rangeKey string, attrs []dynamodb.Attribute) (bool, error) {
+	return t.t.PutItem(hashKey, rangeKey, attrs)
+}
+
+func (t *dynamoTable) AddAttributes(key *dynamodb.Key, attrs []dynamodb.Attribute) (bool, error) {
+	return t.t.AddAttributes(key, attrs)
+}
+
+func (t *dynamoTable) Scan(start *dynamodb.Key) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, error) {
+	return t.t.ScanPartial(nil, start)
+}
+
+// BatchGetItems issues the BatchGetItem request itself rather than through
+// goamz, whose BatchGetItem.Execute discards UnprocessedKeys.
+func (t *dynamoTable) BatchGetItems(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []dynamodb.Key, error) {
+	keyAttr := t.t.Key.KeyAttribute
+	requested := make([]map[string]map[string]string, len(keys))
+	for i, key := range keys {
+		requested[i] = map[string]map[string]string{
+			keyAttr.Name: {keyAttr.Type: key.HashKey}}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"RequestItems": map[string]interface{}{
+			t.t.Name: map[string]interface{}{"Keys": requested}}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := t.query("DynamoDB_20120810.BatchGetItem", string(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp struct {
+		Responses       map[string][]map[string]map[string]json.RawMessage
+		UnprocessedKeys map[string]struct {
+			Keys []map[string]map[string]string
+		}
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]map[string]*dynamodb.Attribute, 0, len(resp.Responses[t.t.Name]))
+	for _, raw := range resp.Responses[t.t.Name] {
+		item, err := parseItem(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+
+	unprocessed := []dynamodb.Key{}
+	for _, key := range resp.UnprocessedKeys[t.t.Name].Keys {
+		for _, v := range key[keyAttr.Name] {
+			unprocessed = append(unprocessed, dynamodb.Key{HashKey: v})
+		}
+	}
+
+	return items, unprocessed, nil
+}
+
+// query signs and sends a request the same way goamz does internally.
+func (t *dynamoTable) query(target, body string) ([]byte, error) {
+	server := t.t.Server
+	req, err := http.NewRequest("POST", server.Region.DynamoDBEndpoint+"/", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format(aws.ISO8601BasicFormat))
+	req.Header.Set("X-Amz-Target", target)
+	if token := server.Auth.Token(); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	aws.NewV4Signer(server.Auth, "dynamodb", server.Region).Sign(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseError(resp, data)
+	}
+	return data, nil
+}
+
+func parseError(resp *http.Response, data []byte) error {
+	var body struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &body)
+
+	code := body.Type
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	return &dynamodb.Error{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Code:       code,
+		Message:    body.Message}
+}
+
+func parseItem(raw map[string]map[string]json.RawMessage) (map[string]*dynamodb.Attribute, error) {
+	item := make(map[string]*dynamodb.Attribute, len(raw))
+	for name, typed := range raw {
+		for t, v := range typed {
+			a := &dynamodb.Attribute{Type: t, Name: name}
+			var err error
+			switch t {
+			case dynamodb.TYPE_STRING_SET, dynamodb.TYPE_NUMBER_SET, dynamodb.TYPE_BINARY_SET:
+				err = json.Unmarshal(v, &a.SetValues)
+			case dynamodb.TYPE_STRING, dynamodb.TYPE_NUMBER, dynamodb.TYPE_BINARY:
+				err = json.Unmarshal(v, &a.Value)
+			default:
+				err = fmt.Errorf("unsupported attribute type %s for %s", t, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			item[name] = a
+		}
+	}
+	return item, nil
+}
+
+// FakeTable is an in-memory Table for tests. It implements the subset of
+// DynamoDB semantics DynamoDBIndex relies on: PutItem replaces an item,
+// AddAttributes unions sets and adds numbers.
+type FakeTable struct {
+	name    string
+	keyName string
+	items   map[string]map[string]*dynamodb.Attribute
+	lock    sync.Mutex
+
+	// BatchLimit, if positive, is the number of keys processed per
+	// BatchGetItems call; the rest are returned unprocessed.
+	BatchLimit int
+
+	// Throttle is the number of subsequent BatchGetItems calls that fail
+	// with a ProvisionedThroughputExceededException.
+	Throttle int
+
+	// MaxSetSize, if positive, stands in for the item size limit: an
+	// AddAttributes that would grow a set past it fails.
+	MaxSetSize int
+}
+
+func NewFakeTable(name, keyName string) *FakeTable {
+	return &FakeTable{
+		name:    name,
+		keyName: keyName,
+		items:   make(map[string]map[string]*dynamodb.Attribute)}
+}
+
+func (t *FakeTable) Name() string {
+	return t.name
+}
+
+func (t *FakeTable) HashKeyName() string {
+	return t.keyName
+}
+
+func (t *FakeTable) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.items)
+}
+
+func (t *FakeTable) newItem(hashKey string) map[string]*dynamodb.Attribute {
+	return map[string]*dynamodb.Attribute{
+		t.keyName: &dynamodb.Attribute{
+			Type:  dynamodb.TYPE_BINARY,
+			Name:  t.keyName,
+			Value: hashKey}}
+}
+
+// checkKey rejects keys that DynamoDB would refuse for the binary hash key
+// of phosphorus tables.
+func checkKey(hashKey string) error {
+	if _, err := base64.StdEncoding.DecodeString(hashKey); err != nil {
+		return &dynamodb.Error{
+			StatusCode: 400,
+			Code:       DDB_VALIDATION,
+			Message:    fmt.Sprintf("Invalid binary key %q: %s", hashKey, err)}
+	}
+	return nil
+}
+
+func (t *FakeTable) PutItem(hashKey string, rangeKey string, attrs []dynamodb.Attribute) (bool, error) {
+	if err := checkKey(hashKey); err != nil {
+		return false, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	item := t.newItem(hashKey)
+	for _, a := range attrs {
+		item[a.Name] = copyAttribute(&a)
+	}
+	t.items[hashKey] = item
+	return true, nil
+}
+
+func (t *FakeTable) AddAttributes(key *dynamodb.Key, attrs []dynamodb.Attribute) (bool, error) {
+	if err := checkKey(key.HashKey); err != nil {
+		return false, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	item, exists := t.items[key.HashKey]
+	if t.MaxSetSize > 0 {
+		for _, a := range attrs {
+			size := len(a.SetValues)
+			if exists && item[a.Name] != nil {
+				size = len(union(append([]string{}, item[a.Name].SetValues...), a.SetValues))
+			}
+			if size > t.MaxSetSize {
+				return false, &dynamodb.Error{
+					StatusCode: 400,
+					Code:       DDB_VALIDATION,
+					Message:    "Item size has exceeded the maximum allowed size"}
+			}
+		}
+	}
+	if !exists {
+		item = t.newItem(key.HashKey)
+		t.items[key.HashKey] = item
+	}
+
+	for _, a := range attrs {
+		old, exists := item[a.Name]
+		if !exists {
+			item[a.Name] = copyAttribute(&a)
+			continue
+		}
+		switch a.Type {
+		case dynamodb.TYPE_STRING_SET, dynamodb.TYPE_NUMBER_SET, dynamodb.TYPE_BINARY_SET:
+			old.SetValues = union(old.SetValues, a.SetValues)
+		case dynamodb.TYPE_NUMBER:
+			x, _ := strconv.ParseInt(old.Value, 10, 64)
+			y, _ := strconv.ParseInt(a.Value, 10, 64)
+			old.Value = strconv.FormatInt(x+y, 10)
+		default:
+			item[a.Name] = copyAttribute(&a)
+		}
+	}
+	return true, nil
+}
+
+func (t *FakeTable) BatchGetItems(keys []dynamodb.Key) ([]map[string]*dynamodb.Attribute, []dynamodb.Key, error) {
+	for _, key := range keys {
+		if err := checkKey(key.HashKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.Throttle > 0 {
+		t.Throttle--
+		return nil, nil, &dynamodb.Error{StatusCode: 400, Code: DDB_TOOMUCH}
+	}
+
+	unprocessed := []dynamodb.Key{}
+	if t.BatchLimit > 0 && len(keys) > t.BatchLimit {
+		unprocessed = append(unprocessed, keys[t.BatchLimit:]...)
+		keys = keys[:t.BatchLimit]
+	}
+
+	items := []map[string]*dynamodb.Attribute{}
+	for _, key := range keys {
+		item, exists := t.items[key.HashKey]
+		if !exists {
+			continue
+		}
+		c := make(map[string]*dynamodb.Attribute, len(item))
+		for k, a := range item {
+			c[k] = copyAttribute(a)
+		}
+		items = append(items, c)
+	}
+	return items, unprocessed, nil
+}
+
+// FAKE_SCAN_PAGE is the number of items per page returned by FakeTable.Scan.
+const FAKE_SCAN_PAGE = 100
+
+func (t *FakeTable) Scan(start *dynamodb.Key) ([]map[string]*dynamodb.Attribute, *dynamodb.Key, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	keys := make([]string, 0, len(t.items))
+	for k, _ := range t.items {
+		if start == nil || k > start.HashKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var last *dynamodb.Key
+	if len(keys) > FAKE_SCAN_PAGE {
+		keys = keys[:FAKE_SCAN_PAGE]
+		last = &dynamodb.Key{HashKey: keys[len(keys)-1]}
+	}
+
+	items := make([]map[string]*dynamodb.Attribute, 0, len(keys))
+	for _, k := range keys {
+		c := make(map[string]*dynamodb.Attribute, len(t.items[k]))
+		for name, a := range t.items[k] {
+			c[name] = copyAttribute(a)
+		}
+		items = append(items, c)
+	}
+	return items, last, nil
+}
+
+func copyAttribute(a *dynamodb.Attribute) *dynamodb.Attribute {
+	c := *a
+	if a.SetValues != nil {
+		c.SetValues = append([]string{}, a.SetValues...)
+	}
+	return &c
+}
+
+func union(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			seen[v] = true
+			a = append(a, v)
+		}
+	}
+	return a
+}
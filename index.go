@@ -16,16 +16,17 @@ package main
 
 import (
 	"encoding/json"
-	"github.com/crowdmob/goamz/aws"
-	"github.com/crowdmob/goamz/dynamodb"
-	"github.com/wsc/phosphorus/environment"
+	"fmt"
 	"github.com/wsc/phosphorus/random"
+	"github.com/wsc/phosphorus/ratelimit"
 	"github.com/wsc/phosphorus/schema"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
-	"time"
+	"sync/atomic"
+	"syscall"
 )
 
 var cmdIndex = &Command{
@@ -35,12 +36,14 @@ var cmdIndex = &Command{
 }
 
 var (
-	indexDir         string
-	indexSchema      string // -schema flag
-	indexSourceDef   string // -sourcedef flag
-	indexIn          string // -in flag
-	indexSourceTable string
-	indexIndexTable  string
+	indexDir        string
+	indexSchema     string // -schema flag
+	indexSourceDef  string // -sourcedef flag
+	indexIn         string // -in flag
+	indexIndexDef   string // -index flag
+	indexCheckpoint string // -checkpoint flag
+	indexResume     bool   // -resume flag
+	indexInterval   int    // -interval flag
 )
 
 func init() {
@@ -48,28 +51,25 @@ func init() {
 	cmdIndex.Flag.StringVar(&indexSchema, "schema", "", "")
 	cmdIndex.Flag.StringVar(&indexSourceDef, "sourcedef", "", "")
 	cmdIndex.Flag.StringVar(&indexIn, "in", "", "")
-	cmdIndex.Flag.StringVar(&indexSourceTable, "sourcetable", "", "")
-	cmdIndex.Flag.StringVar(&indexIndexTable, "indextable", "", "")
+	cmdIndex.Flag.StringVar(&indexIndexDef, "index", "", "")
+	cmdIndex.Flag.StringVar(&indexCheckpoint, "checkpoint", "", "")
+	cmdIndex.Flag.BoolVar(&indexResume, "resume", false, "")
+	cmdIndex.Flag.IntVar(&indexInterval, "interval", 100000, "")
 }
 
 func runIndex(cmd *Command, args []string) {
+	if indexInterval <= 0 {
+		log.Println("-interval must be positive")
+		cmd.Usage()
+	}
+
 	log.Println("hello")
 	// get randomstore
 	rs := random.NewRandomStore(indexDir)
 	log.Println("randomstore")
 
 	// load schema
-	s := &schema.Schema{}
-	file, err := os.Open(indexSchema)
-	if err != nil {
-		panic(err)
-	}
-
-	err = s.Load(file)
-	if err != nil {
-		panic(err)
-	}
-	file.Close()
+	s := loadSchema(indexSchema)
 	log.Println("schema")
 
 	// sum := 0
@@ -95,63 +95,162 @@ func runIndex(cmd *Command, args []string) {
 	src.Concurrent = 5
 	log.Println("filesource")
 
-	c, err := src.GetChannel()
-	if err != nil {
-		panic(err)
-	}
-	log.Println("getchannel")
-
-	// aws :(
-	now := time.Now()
-	expires := now.Add(time.Duration(60) * time.Minute)
-	auth, err := aws.EnvAuth()
-
-	if err != nil {
-		auth, err = aws.GetAuth("", "", "", expires)
+	cp := schema.NewCheckpoint()
+	if indexResume {
+		if indexCheckpoint == "" {
+			log.Println("-resume requires -checkpoint")
+			os.Exit(1)
+		}
+		cp, err = schema.LoadCheckpoint(indexCheckpoint)
 		if err != nil {
 			panic(err)
 		}
-		panic(err)
+		src.Skip = cp.Skip()
+		log.Printf("resuming after %d records\n", cp.Total())
 	}
-	log.Println("envauth")
 
-	dynamo := &dynamodb.Server{auth, aws.USEast}
-	sourceT := dynTable(dynamo, indexSourceTable)
-	indexT := dynTable(dynamo, indexIndexTable)
-	log.Println("tables")
+	c, err := src.GetChannel()
+	if err != nil {
+		panic(err)
+	}
+	log.Println("getchannel")
 
-	ix := environment.NewDynamoDBIndex(s, indexT, sourceT)
+	def := loadIndexDef(indexIndexDef)
+	ix := openIndex(def, s)
 	log.Println("newindex")
 
 	log.Println("go")
 
+	x := &indexer{
+		ix:          ix,
+		rs:          rs,
+		cp:          cp,
+		concurrency: def.Concurrency,
+		checkpoint:  indexCheckpoint,
+		interval:    indexInterval}
+	if !x.run(c) {
+		os.Exit(1)
+	}
+	log.Println("goodbye")
+	os.Exit(0)
+}
+
+// indexer writes records into an index on concurrent workers. Every
+// interval records, if checkpoint is set, the index is flushed and cp is
+// saved to checkpoint.
+type indexer struct {
+	ix          schema.Index
+	rs          schema.RandomProvider
+	cp          *schema.Checkpoint
+	concurrency int
+	checkpoint  string
+	interval    int
+}
+
+// run indexes the records of c until c is closed or the process is
+// interrupted, then flushes the index and reports. It returns false if
+// anything failed.
+func (x *indexer) run(c chan *schema.Record) bool {
+	// records are handed to the workers one at a time so that, at each
+	// checkpoint, everything marked in cp has been written and can be
+	// flushed before the checkpoint is saved.
+	work := make(chan *schema.Record)
+	var pending sync.WaitGroup
 	var wait sync.WaitGroup
+	var written, failed int64
 
-	for i := 0; i < 128; i++ {
+	for i := 0; i < x.concurrency; i++ {
 		wait.Add(1)
 		go func() {
-			for record := range c {
-				ix.Write(record, rs)
+			for record := range work {
+				err := x.ix.Write(record, x.rs)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					errMsg(fmt.Sprintf("record %d", record.Id), err)
+				} else {
+					atomic.AddInt64(&written, 1)
+				}
+				pending.Done()
 			}
 			wait.Done()
 		}()
 	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	n := 0
+	ok := true
+dispatch:
+	for {
+		select {
+		case sig := <-sigs:
+			msg("index", fmt.Sprintf("%s, draining workers", sig))
+			ok = false
+			break dispatch
+		case record, more := <-c:
+			if !more {
+				break dispatch
+			}
+			pending.Add(1)
+			work <- record
+			x.cp.Mark(record)
+			n++
+			if x.checkpoint != "" && n%x.interval == 0 {
+				pending.Wait()
+				err := x.commit(atomic.LoadInt64(&failed))
+				if err != nil {
+					errMsg("checkpoint", err)
+					ok = false
+					break dispatch
+				}
+			}
+		}
+	}
+	signal.Stop(sigs)
+	close(work)
 	log.Println("wait")
 	wait.Wait()
-	log.Println("goodbye")
 
+	// flush whatever is still buffered, even after an interrupt, so that
+	// everything that was written is also queryable
+	err := x.commit(failed)
+	if err != nil {
+		errMsg("flush", err)
+		ok = false
+	}
+
+	msg("index", fmt.Sprintf("%d records written, %d failed", written, failed))
+	if m, ok := x.ix.(interface {
+		Metrics() map[string]ratelimit.Metrics
+	}); ok {
+		for name, metrics := range m.Metrics() {
+			msg(name, fmt.Sprintf("%+v", metrics))
+		}
+	}
+	return ok && failed == 0
 }
 
-func dynTable(s *dynamodb.Server, name string) *dynamodb.Table {
-	td, err := s.DescribeTable(name)
+// commit flushes the index and then records the checkpoint, so a resumed
+// run never skips records whose buckets were still buffered in memory.
+// The checkpoint is not advanced once any write has failed, so that a
+// resumed run retries the failed records.
+func (x *indexer) commit(failed int64) error {
+	err := x.ix.Flush()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if x.checkpoint == "" {
+		return nil
+	}
+	if failed > 0 {
+		msg("checkpoint", fmt.Sprintf("not saved, %d failed writes", failed))
+		return nil
 	}
-	pk, err := td.BuildPrimaryKey()
+	err = x.cp.Save(x.checkpoint)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	t := s.NewTable(name, pk)
-	return t
+	log.Printf("checkpoint: %d records\n", x.cp.Total())
+	return nil
 }
@@ -0,0 +1,162 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wsc/phosphorus/schema"
+	"os"
+	"sort"
+	"strings"
+)
+
+var cmdInspect = &Command{
+	Run:       runInspect,
+	UsageLine: "inspect",
+	Short:     "describe a schema file",
+}
+
+var (
+	inspectSchema string // -schema flag
+	inspectJSON   bool   // -json flag
+	inspectTop    int    // -top flag
+)
+
+func init() {
+	cmdInspect.Flag.StringVar(&inspectSchema, "schema", "", "")
+	cmdInspect.Flag.BoolVar(&inspectJSON, "json", false, "")
+	cmdInspect.Flag.IntVar(&inspectTop, "top", 10, "")
+}
+
+type schemaInfo struct {
+	HashCount    int          `json:"hash_count"`
+	Width        int          `json:"chunk_size"`
+	SignatureLen int          `json:"signature_len"`
+	Fields       []*fieldInfo `json:"fields"`
+}
+
+type fieldInfo struct {
+	Comment    string               `json:"comment"`
+	Attrs      []string             `json:"attrs"`
+	Transforms []*schema.TransformI `json:"transforms"`
+	Classifier string               `json:"classifier"`
+	Dimension  int                  `json:"dimension"`
+	Hash       int64                `json:"hash,omitempty"`
+	Highest    []*schema.TermWeight `json:"highest_idf,omitempty"`
+	Lowest     []*schema.TermWeight `json:"lowest_idf,omitempty"`
+}
+
+type byWeight []*schema.TermWeight
+
+func (w byWeight) Len() int { return len(w) }
+func (w byWeight) Less(i, j int) bool {
+	if w[i].Weight == w[j].Weight {
+		return w[i].Term < w[j].Term
+	}
+	return w[i].Weight > w[j].Weight
+}
+func (w byWeight) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+
+func inspect(s *schema.Schema, top int) *schemaInfo {
+	info := &schemaInfo{
+		HashCount:    s.HashCount,
+		Width:        s.Width,
+		SignatureLen: s.SignatureLen()}
+
+	for _, f := range s.Fields {
+		fi := &fieldInfo{
+			Comment:    f.Comment,
+			Attrs:      f.Attrs,
+			Transforms: f.Transforms,
+			Classifier: fmt.Sprintf("%T", f.Classifier),
+			Dimension:  f.Classifier.Dimension()}
+
+		if h, ok := f.Classifier.(interface {
+			Hash() int64
+		}); ok {
+			fi.Hash = h.Hash()
+		}
+
+		if c, ok := f.Classifier.(*schema.TfIdfClassifier); ok {
+			terms := make([]*schema.TermWeight, 0, len(c.Counts))
+			for term := range c.Counts {
+				w, known := c.Weight(term)
+				terms = append(terms, &schema.TermWeight{Term: term, Weight: w, Known: known})
+			}
+			sort.Sort(byWeight(terms))
+
+			n := top
+			if n > len(terms) {
+				n = len(terms)
+			}
+			fi.Highest = terms[:n]
+			for i := len(terms) - 1; i >= len(terms)-n; i-- {
+				fi.Lowest = append(fi.Lowest, terms[i])
+			}
+		}
+
+		info.Fields = append(info.Fields, fi)
+	}
+	return info
+}
+
+func transforms(ts []*schema.TransformI) string {
+	names := make([]string, 0, len(ts))
+	for _, t := range ts {
+		if len(t.Arguments) > 0 {
+			args, _ := json.Marshal(t.Arguments)
+			names = append(names, fmt.Sprintf("%s%s", t.Name, args))
+		} else {
+			names = append(names, t.Name)
+		}
+	}
+	return strings.Join(names, " | ")
+}
+
+func terms(tws []*schema.TermWeight) string {
+	out := make([]string, 0, len(tws))
+	for _, tw := range tws {
+		out = append(out, fmt.Sprintf("%s (%.3f)", tw.Term, tw.Weight))
+	}
+	return strings.Join(out, ", ")
+}
+
+func runInspect(cmd *Command, args []string) {
+	info := inspect(loadSchema(inspectSchema), inspectTop)
+
+	if inspectJSON {
+		enc := json.NewEncoder(os.Stdout)
+		if err := enc.Encode(info); err != nil {
+			panic(err)
+		}
+		os.Exit(0)
+	}
+
+	fmt.Printf("hash count:       %d\n", info.HashCount)
+	fmt.Printf("chunk size:       %d\n", info.Width)
+	fmt.Printf("signature length: %d\n", info.SignatureLen)
+	for i, f := range info.Fields {
+		fmt.Printf("\nfield %d: %s\n", i, f.Comment)
+		fmt.Printf("  attrs:       %s\n", strings.Join(f.Attrs, ", "))
+		fmt.Printf("  transforms:  %s\n", transforms(f.Transforms))
+		fmt.Printf("  classifier:  %s\n", f.Classifier)
+		fmt.Printf("  vocabulary:  %d\n", f.Dimension)
+		fmt.Printf("  hash:        %016x\n", uint64(f.Hash))
+		fmt.Printf("  highest idf: %s\n", terms(f.Highest))
+		fmt.Printf("  lowest idf:  %s\n", terms(f.Lowest))
+	}
+	os.Exit(0)
+}
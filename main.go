@@ -17,6 +17,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/wsc/phosphorus/environment"
+	"github.com/wsc/phosphorus/schema"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -50,6 +53,11 @@ type Command struct {
 var commands = []*Command{
 	cmdSchema,
 	cmdIndex,
+	cmdQuery,
+	cmdServer,
+	cmdMigrate,
+	cmdInspect,
+	cmdReindex,
 	cmdHash,
 }
 
@@ -111,3 +119,39 @@ func msg(resource, disposition string) {
 func errMsg(resource string, err error) {
 	log.Printf("%s: %s\n", resource, err)
 }
+
+func loadSchema(path string) *schema.Schema {
+	s := &schema.Schema{}
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	err = s.Load(file)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func loadIndexDef(path string) *environment.IndexDef {
+	def := &environment.IndexDef{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	err = def.LoadJSON(data)
+	if err != nil {
+		panic(err)
+	}
+	return def
+}
+
+func openIndex(def *environment.IndexDef, s schema.Signer) schema.Index {
+	ix, err := environment.Open(def, s)
+	if err != nil {
+		panic(err)
+	}
+	return ix
+}
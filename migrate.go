@@ -0,0 +1,65 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/wsc/phosphorus/environment"
+	"github.com/wsc/phosphorus/ratelimit"
+	"os"
+)
+
+var cmdMigrate = &Command{
+	Run:       runMigrate,
+	UsageLine: "migrate",
+	Short:     "copy a DynamoDB index table to a new bucket key format",
+}
+
+var (
+	migrateFrom string // -from flag
+	migrateTo   string // -to flag
+)
+
+func init() {
+	cmdMigrate.Flag.StringVar(&migrateFrom, "from", "", "")
+	cmdMigrate.Flag.StringVar(&migrateTo, "to", "", "")
+}
+
+func runMigrate(cmd *Command, args []string) {
+	from := loadIndexDef(migrateFrom)
+	to := loadIndexDef(migrateTo)
+
+	fromT, _, err := environment.OpenTables(from)
+	if err != nil {
+		panic(err)
+	}
+	toT, _, err := environment.OpenTables(to)
+	if err != nil {
+		panic(err)
+	}
+
+	m := ratelimit.New(ratelimit.Config{Rate: to.IndexThroughput})
+
+	err = environment.MigrateKeys(
+		fromT, environment.KeyFormat(from.KeyFormat),
+		toT, environment.KeyFormat(to.KeyFormat),
+		m, func(n int) { msg("migrate", fmt.Sprintf("%d items", n)) })
+	m.Stop()
+	if err != nil {
+		panic(err)
+	}
+	msg("migrate", "done")
+	os.Exit(0)
+}
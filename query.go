@@ -0,0 +1,119 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/wsc/phosphorus/random"
+	"github.com/wsc/phosphorus/schema"
+	"log"
+	"os"
+)
+
+var cmdQuery = &Command{
+	Run:       runQuery,
+	UsageLine: "query",
+	Short:     "query the index with records read from stdin",
+}
+
+var (
+	queryDir      string
+	querySchema   string // -schema flag
+	queryIndexDef string // -index flag
+	queryLimit    int    // -limit flag
+	queryExplain  bool   // -explain flag
+)
+
+func init() {
+	cmdQuery.Flag.StringVar(&queryDir, "dir", "", "")
+	cmdQuery.Flag.StringVar(&querySchema, "schema", "", "")
+	cmdQuery.Flag.StringVar(&queryIndexDef, "index", "", "")
+	cmdQuery.Flag.IntVar(&queryLimit, "limit", 10, "")
+	cmdQuery.Flag.BoolVar(&queryExplain, "explain", false, "")
+}
+
+// match is the wire format of a schema.Result.
+type match struct {
+	Id      uint32            `json:"id"`
+	Attrs   map[string]string `json:"attrs"`
+	Matches int               `json:"matches"`
+
+	Explanation *schema.Explanation `json:"explanation,omitempty"`
+}
+
+func matches(results []schema.Result, limit int) []match {
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	out := make([]match, 0, len(results))
+	for _, r := range results {
+		out = append(out, match{Id: r.Record.Id, Attrs: r.Record.Attrs, Matches: r.Matches})
+	}
+	return out
+}
+
+// explain fills in the explanation of each match against the query attrs,
+// counting the chunks found by probing up to probes neighbouring buckets.
+func explain(s *schema.Schema, attrs map[string]string, ms []match, rs schema.RandomProvider, probes int) error {
+	for i := range ms {
+		x, err := s.Explain(attrs, ms[i].Attrs, rs, probes)
+		if err != nil {
+			return err
+		}
+		ms[i].Explanation = x
+	}
+	return nil
+}
+
+// runQuery reads one JSON object of attributes per line and writes the
+// best matches for each as one JSON array per line. With -explain, each
+// match also tells which chunks collided and what each field contributed.
+func runQuery(cmd *Command, args []string) {
+	rs := random.NewRandomStore(queryDir)
+	s := loadSchema(querySchema)
+	def := loadIndexDef(queryIndexDef)
+	ix := openIndex(def, s)
+
+	enc := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		attrs := make(map[string]string)
+		err := json.Unmarshal(scanner.Bytes(), &attrs)
+		if err != nil {
+			errMsg("query", err)
+			continue
+		}
+
+		results, err := ix.Query(attrs, rs)
+		if err != nil {
+			errMsg("query", err)
+			continue
+		}
+		ms := matches(results, queryLimit)
+		if queryExplain {
+			if err := explain(s, attrs, ms, rs, def.Probes); err != nil {
+				errMsg("query", err)
+				continue
+			}
+		}
+		enc.Encode(ms)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
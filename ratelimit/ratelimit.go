@@ -0,0 +1,218 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit implements an adaptive token bucket. Each interval the
+// bucket is refilled to the current rate; the rate grows when an interval's
+// tokens are used up without failures and shrinks when callers report
+// failures. Both are multiplicative, by Config.Increase and
+// Config.Decrease, so a limiter finds its level in few intervals.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrStopped = errors.New("ratelimit: limiter stopped")
+
+type Config struct {
+	// Rate is the initial number of tokens per interval.
+	Rate int
+	// Interval between refills. Defaults to 5s.
+	Interval time.Duration
+	// Increase multiplies the rate after an interval whose tokens were
+	// all used without failures. Defaults to 1.1; 1 disables growth.
+	Increase float64
+	// Decrease multiplies the rate after an interval with failures.
+	// Defaults to 0.5; 1 disables backoff.
+	Decrease float64
+	// Min and Max bound the rate. Min defaults to 1; a Max of 0 means
+	// unbounded.
+	Min int
+	Max int
+}
+
+const (
+	DEFAULT_INTERVAL = 5 * time.Second
+	DEFAULT_INCREASE = 1.1
+	DEFAULT_DECREASE = 0.5
+)
+
+func (c *Config) defaults() {
+	if c.Interval == 0 {
+		c.Interval = DEFAULT_INTERVAL
+	}
+	if c.Increase == 0 {
+		c.Increase = DEFAULT_INCREASE
+	}
+	if c.Decrease == 0 {
+		c.Decrease = DEFAULT_DECREASE
+	}
+	if c.Min < 1 {
+		c.Min = 1
+	}
+}
+
+// Metrics is a snapshot of a limiter's state and counters.
+type Metrics struct {
+	Rate      int           // current tokens per interval
+	Available int           // tokens left in this interval
+	Granted   int64         // tokens handed out
+	Waits     int64         // calls to Wait that had to block
+	Waited    time.Duration // total time spent blocked in Wait
+	Failures  int64         // calls to Backoff
+	Intervals int64         // refills so far
+}
+
+type Limiter struct {
+	config   Config
+	lock     sync.Mutex
+	rate     int
+	tokens   int
+	fails    int
+	refilled chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  bool
+	metrics  Metrics
+}
+
+// New returns a started limiter. Call Stop to release it.
+func New(config Config) *Limiter {
+	config.defaults()
+	l := &Limiter{
+		config:   config,
+		refilled: make(chan struct{}),
+		stop:     make(chan struct{})}
+	l.rate = l.clamp(config.Rate)
+	l.tokens = l.rate
+	go l.run()
+	return l
+}
+
+func (l *Limiter) clamp(rate int) int {
+	if rate < l.config.Min {
+		rate = l.config.Min
+	}
+	if l.config.Max > 0 && rate > l.config.Max {
+		rate = l.config.Max
+	}
+	return rate
+}
+
+func (l *Limiter) run() {
+	ticker := time.NewTicker(l.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.refill()
+		}
+	}
+}
+
+func (l *Limiter) refill() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// a tick can race with Stop, which has already closed refilled
+	if l.stopped {
+		return
+	}
+
+	if l.fails > 0 {
+		l.rate = l.clamp(int(float64(l.rate) * l.config.Decrease))
+	} else if l.tokens == 0 {
+		l.rate = l.clamp(int(math.Ceil(float64(l.rate) * l.config.Increase)))
+	}
+	l.fails = 0
+	l.tokens = l.rate
+	l.metrics.Intervals++
+
+	close(l.refilled)
+	l.refilled = make(chan struct{})
+}
+
+// Wait blocks until n tokens are available and takes them. A request for
+// more than the rate is granted once the bucket is full, so it cannot
+// block forever. Wait returns ctx's error if ctx is done first, or
+// ErrStopped once the limiter is stopped.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	var start time.Time
+	for {
+		l.lock.Lock()
+		if l.stopped {
+			l.lock.Unlock()
+			return ErrStopped
+		}
+		if l.tokens >= n || l.tokens >= l.rate {
+			l.tokens -= n
+			if l.tokens < 0 {
+				l.tokens = 0
+			}
+			l.metrics.Granted += int64(n)
+			if !start.IsZero() {
+				l.metrics.Waits++
+				l.metrics.Waited += time.Since(start)
+			}
+			l.lock.Unlock()
+			return nil
+		}
+		refilled := l.refilled
+		l.lock.Unlock()
+
+		if start.IsZero() {
+			start = time.Now()
+		}
+		select {
+		case <-refilled:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Backoff reports a failure, such as a throttling error, which lowers the
+// rate at the next interval.
+func (l *Limiter) Backoff() {
+	l.lock.Lock()
+	l.fails++
+	l.metrics.Failures++
+	l.lock.Unlock()
+}
+
+// Stop halts refills and releases any blocked callers with ErrStopped.
+func (l *Limiter) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+		l.lock.Lock()
+		l.stopped = true
+		close(l.refilled)
+		l.lock.Unlock()
+	})
+}
+
+func (l *Limiter) Metrics() Metrics {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.metrics
+	m.Rate = l.rate
+	m.Available = l.tokens
+	return m
+}
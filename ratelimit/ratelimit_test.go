@@ -0,0 +1,115 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	l := New(Config{Rate: 2, Interval: 10 * time.Millisecond})
+	defer l.Stop()
+
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		err := l.Wait(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := l.Metrics()
+	if m.Granted != 4 {
+		t.Errorf("granted %d", m.Granted)
+	}
+	if m.Waits == 0 {
+		t.Error("expected a blocked wait")
+	}
+}
+
+func TestWaitLargerThanRate(t *testing.T) {
+	l := New(Config{Rate: 2, Interval: time.Hour})
+	defer l.Stop()
+
+	err := l.Wait(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitCancel(t *testing.T) {
+	l := New(Config{Rate: 1, Interval: time.Hour})
+	defer l.Stop()
+
+	l.Wait(context.Background(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.Wait(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	l := New(Config{Rate: 1, Interval: time.Hour})
+	l.Wait(context.Background(), 1)
+
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(context.Background(), 1)
+	}()
+	l.Stop()
+	l.Stop()
+
+	if err := <-done; err != ErrStopped {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestStopDuringRefills(t *testing.T) {
+	l := New(Config{Rate: 1, Interval: time.Hour})
+	l.Stop()
+	l.refill()
+
+	for i := 0; i < 100; i++ {
+		l := New(Config{Rate: 1, Interval: time.Microsecond})
+		time.Sleep(time.Duration(i%10) * time.Microsecond)
+		l.Stop()
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	l := New(Config{Rate: 10, Interval: time.Hour, Increase: 2, Decrease: 0.5})
+	defer l.Stop()
+
+	l.Wait(context.Background(), 10)
+	l.refill()
+	if l.Metrics().Rate != 20 {
+		t.Errorf("rate %d after drained interval", l.Metrics().Rate)
+	}
+
+	l.Backoff()
+	l.refill()
+	if l.Metrics().Rate != 10 {
+		t.Errorf("rate %d after failure", l.Metrics().Rate)
+	}
+
+	l.refill()
+	if l.Metrics().Rate != 10 {
+		t.Errorf("rate %d after idle interval", l.Metrics().Rate)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/wsc/phosphorus/environment"
+	"github.com/wsc/phosphorus/random"
+	"github.com/wsc/phosphorus/schema"
+	"log"
+	"os"
+)
+
+var cmdReindex = &Command{
+	Run:       runReindex,
+	UsageLine: "reindex",
+	Short:     "copy the records of an index into a new index with a new schema",
+}
+
+var (
+	reindexDir        string
+	reindexSchema     string // -schema flag
+	reindexFrom       string // -from flag
+	reindexTo         string // -to flag
+	reindexCheckpoint string // -checkpoint flag
+	reindexResume     bool   // -resume flag
+	reindexInterval   int    // -interval flag
+)
+
+func init() {
+	cmdReindex.Flag.StringVar(&reindexDir, "dir", "", "")
+	cmdReindex.Flag.StringVar(&reindexSchema, "schema", "", "")
+	cmdReindex.Flag.StringVar(&reindexFrom, "from", "", "")
+	cmdReindex.Flag.StringVar(&reindexTo, "to", "", "")
+	cmdReindex.Flag.StringVar(&reindexCheckpoint, "checkpoint", "", "")
+	cmdReindex.Flag.BoolVar(&reindexResume, "resume", false, "")
+	cmdReindex.Flag.IntVar(&reindexInterval, "interval", 100000, "")
+}
+
+// runReindex reads the records stored by the index described by -from and
+// writes them, signed with -schema, into the index described by -to. The
+// checkpoint counts records in the order the old index returns them.
+func runReindex(cmd *Command, args []string) {
+	if reindexInterval <= 0 {
+		log.Println("-interval must be positive")
+		cmd.Usage()
+	}
+
+	rs := random.NewRandomStore(reindexDir)
+	s := loadSchema(reindexSchema)
+
+	store, err := environment.OpenRecords(loadIndexDef(reindexFrom))
+	if err != nil {
+		panic(err)
+	}
+
+	def := loadIndexDef(reindexTo)
+	ix := openIndex(def, s)
+
+	cp := schema.NewCheckpoint()
+	if reindexResume {
+		if reindexCheckpoint == "" {
+			log.Println("-resume requires -checkpoint")
+			os.Exit(1)
+		}
+		cp, err = schema.LoadCheckpoint(reindexCheckpoint)
+		if err != nil {
+			panic(err)
+		}
+	}
+	skip := cp.Skip()[reindexFrom]
+	if skip > 0 {
+		log.Printf("resuming after %d records\n", skip)
+	}
+
+	stored := make(chan *schema.Record)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- store.Records(skip, stored)
+	}()
+
+	// number the records for the checkpoint, and report progress
+	c := make(chan *schema.Record)
+	read := make(chan struct{})
+	var readErr error
+	go func() {
+		seq := skip
+		for record := range stored {
+			seq++
+			record.Source = reindexFrom
+			record.Seq = seq
+			c <- record
+			if seq%reindexInterval == 0 {
+				msg("reindex", fmt.Sprintf("%d records read", seq))
+			}
+		}
+		readErr = <-errs
+		close(read)
+		close(c)
+	}()
+
+	x := &indexer{
+		ix:          ix,
+		rs:          rs,
+		cp:          cp,
+		concurrency: def.Concurrency,
+		checkpoint:  reindexCheckpoint,
+		interval:    reindexInterval}
+	ok := x.run(c)
+
+	select {
+	case <-read:
+		if readErr != nil {
+			errMsg(reindexFrom, readErr)
+			ok = false
+		}
+	default:
+		// interrupted before the old index was read to the end
+	}
+	if !ok {
+		os.Exit(1)
+	}
+	msg("reindex", "done")
+	os.Exit(0)
+}
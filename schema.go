@@ -16,6 +16,9 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/wsc/phosphorus/environment"
 	"github.com/wsc/phosphorus/schema"
 	"io/ioutil"
 	"log"
@@ -33,6 +36,10 @@ var (
 	schemaSourceDef string // -sourcedef flag
 	schemaIn        string // -in flag
 	schemaOut       string // -out flag
+	schemaFormat    string // -format flag
+	schemaUpdate    string // -update flag
+	schemaCheck     bool   // -check flag
+	schemaIndexDef  string // -index flag, with -check
 )
 
 func init() {
@@ -42,18 +49,49 @@ func init() {
 	cmdSchema.Flag.StringVar(&schemaSchemaDef, "schemadef", "", "")
 	cmdSchema.Flag.StringVar(&schemaIn, "in", "", "")
 	cmdSchema.Flag.StringVar(&schemaOut, "out", "", "")
+	cmdSchema.Flag.StringVar(&schemaFormat, "format", schema.FORMAT_GOB, "")
+	cmdSchema.Flag.StringVar(&schemaUpdate, "update", "", "")
+	cmdSchema.Flag.BoolVar(&schemaCheck, "check", false, "")
+	cmdSchema.Flag.StringVar(&schemaIndexDef, "index", "", "")
 }
 
 func runSchema(cmd *Command, args []string) {
-	s := &schema.Schema{}
-	sDef, err := ioutil.ReadFile(schemaSchemaDef)
-	if err != nil {
-		log.Println(err)
+	if len(args) > 0 && args[0] == "merge" {
+		runSchemaMerge(args[1:])
+		return
+	}
+	if schemaCheck {
+		runSchemaCheck()
+		return
+	}
+
+	switch schemaFormat {
+	case schema.FORMAT_GOB, schema.FORMAT_JSON, schema.FORMAT_JSON_GZIP:
+	default:
+		log.Printf("unknown schema format: %s\n", schemaFormat)
 		os.Exit(1)
 	}
-	err = s.LoadJSON(sDef)
-	if err != nil {
-		panic(err)
+
+	var s *schema.Schema
+	if schemaUpdate != "" {
+		// learning new terms only keeps signatures stable with hashed
+		// projection, whose weights were frozen when the schema was saved
+		s = loadSchema(schemaUpdate)
+		if s.Projection != schema.PROJECTION_HASHED {
+			log.Printf("%s: can't update a schema without hashed projection\n", schemaUpdate)
+			os.Exit(1)
+		}
+	} else {
+		s = &schema.Schema{}
+		sDef, err := ioutil.ReadFile(schemaSchemaDef)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		err = s.LoadJSON(sDef)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	src := &schema.FileSource{}
@@ -73,14 +111,110 @@ func runSchema(cmd *Command, args []string) {
 		panic(err)
 	}
 	s.LearnRecords(c)
+	s.Freeze()
 
-	file, err := os.Create(schemaOut)
+	err = saveSchema(s, schemaOut, schemaFormat)
 	if err != nil {
 		panic(err)
 	}
-	defer file.Close()
-	err = s.Save(file)
+	os.Exit(0)
+}
+
+// saveSchema writes s to the file path in format.
+func saveSchema(s *schema.Schema, path, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = s.SaveFormat(file, format)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runSchemaMerge combines schemas learned from different partitions of the
+// data with the same schema definition:
+//
+//	schema merge -out 'file.schema' [-format gob] part1.schema part2.schema ...
+func runSchemaMerge(args []string) {
+	fs := flag.NewFlagSet("schema merge", flag.ExitOnError)
+	out := fs.String("out", "", "")
+	format := fs.String("format", schema.FORMAT_GOB, "")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		log.Println("schema merge: no input schemas")
+		os.Exit(1)
+	}
+
+	s := loadSchema(fs.Arg(0))
+	for _, path := range fs.Args()[1:] {
+		err := s.Merge(loadSchema(path))
+		if err != nil {
+			log.Printf("%s: %s\n", path, err)
+			os.Exit(1)
+		}
+	}
+	s.Freeze()
+
+	err := saveSchema(s, *out, *format)
 	if err != nil {
 		panic(err)
 	}
+	os.Exit(0)
+}
+
+// runSchemaCheck reports every problem in the schema definition, checked
+// against the source definition and the index definition if given, and
+// exits non-zero if there are any.
+func runSchemaCheck() {
+	sDef, err := ioutil.ReadFile(schemaSchemaDef)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	s := &schema.Schema{}
+	err = json.Unmarshal(sDef, s)
+	if err != nil {
+		log.Printf("%s: %s\n", schemaSchemaDef, err)
+		os.Exit(1)
+	}
+
+	var problems []string
+	report := func(err error) {
+		if e, ok := err.(*schema.ValidationError); ok {
+			problems = append(problems, e.Problems...)
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	report(s.Validate())
+	if schemaSourceDef != "" {
+		src := &schema.FileSource{}
+		srcDef, err := ioutil.ReadFile(schemaSourceDef)
+		if err == nil {
+			err = json.Unmarshal(srcDef, &src)
+		}
+		if err != nil {
+			report(err)
+		} else {
+			report(s.ValidateSource(src.Fields))
+		}
+	}
+	// backend limits depend on the signature shape only
+	if schemaIndexDef != "" && s.Width > 0 {
+		report(environment.Validate(loadIndexDef(schemaIndexDef), s))
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", schemaSchemaDef, problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok\n", schemaSchemaDef)
+	os.Exit(0)
 }
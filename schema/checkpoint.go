@@ -0,0 +1,113 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint records how many records of each source file have been
+// committed to an index. It is only meaningful once the index has been
+// flushed, so callers must Save it after a successful Index.Flush.
+type Checkpoint struct {
+	Files map[string]int `json:"files"`
+	lock  sync.Mutex
+}
+
+func NewCheckpoint() *Checkpoint {
+	return &Checkpoint{Files: make(map[string]int)}
+}
+
+// Mark notes that every record of r's source up to and including r has
+// been handed to the index.
+func (c *Checkpoint) Mark(r *Record) {
+	if r.Source == "" {
+		return
+	}
+	c.lock.Lock()
+	if r.Seq > c.Files[r.Source] {
+		c.Files[r.Source] = r.Seq
+	}
+	c.lock.Unlock()
+}
+
+// Skip returns a copy of the per-file counts, suitable for FileSource.Skip.
+func (c *Checkpoint) Skip() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	skip := make(map[string]int, len(c.Files))
+	for k, v := range c.Files {
+		skip[k] = v
+	}
+	return skip
+}
+
+func (c *Checkpoint) Total() (n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, v := range c.Files {
+		n += v
+	}
+	return
+}
+
+// Save writes the checkpoint to path, replacing it atomically.
+func (c *Checkpoint) Save(path string) (err error) {
+	c.lock.Lock()
+	data, err := json.Marshal(c)
+	c.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint reads a checkpoint from path. A missing file yields an
+// empty checkpoint.
+func LoadCheckpoint(path string) (c *Checkpoint, err error) {
+	c = NewCheckpoint()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, c)
+	if c.Files == nil {
+		c.Files = make(map[string]int)
+	}
+	return
+}
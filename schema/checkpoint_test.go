@@ -0,0 +1,91 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheckpointPersist(t *testing.T) {
+	tempdir := testdir()
+	defer os.RemoveAll(tempdir)
+
+	path := filepath.Join(tempdir, "checkpoint")
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Error(err)
+	}
+	if cp.Total() != 0 {
+		t.Fail()
+	}
+
+	cp.Mark(&Record{Id: 1, Source: "a", Seq: 2})
+	cp.Mark(&Record{Id: 2, Source: "a", Seq: 1})
+	cp.Mark(&Record{Id: 3, Source: "b", Seq: 5})
+	err = cp.Save(path)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cp2, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(cp2.Files, map[string]int{"a": 2, "b": 5}) {
+		t.Errorf("%v", cp2.Files)
+	}
+}
+
+func TestSourceSkip(t *testing.T) {
+	tempdir := testdir()
+	defer os.RemoveAll(tempdir)
+
+	s := &FileSource{}
+	err := json.Unmarshal([]byte(FILESOURCE), &s)
+	if err != nil {
+		t.Error(err)
+	}
+	s.Glob = filepath.Join(tempdir, "*")
+	s.Skip = map[string]int{
+		filepath.Join(tempdir, "csv"):  3,
+		filepath.Join(tempdir, "csv2"): 1}
+
+	c, err := s.GetChannel()
+	if err != nil {
+		t.Error(err)
+	}
+
+	cp := NewCheckpoint()
+	actualFruits := []string{}
+	for r := range c {
+		actualFruits = append(actualFruits, r.Attrs["fruit"])
+		cp.Mark(r)
+	}
+	sort.Strings(actualFruits)
+
+	expected := []string{"APRICOT", "ORANGE", "ORANGE", "PEAR"}
+	if !reflect.DeepEqual(expected, actualFruits) {
+		t.Errorf("%v != %v", expected, actualFruits)
+	}
+
+	if cp.Files[filepath.Join(tempdir, "csv")] != 4 {
+		t.Errorf("%v", cp.Files)
+	}
+}
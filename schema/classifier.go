@@ -31,13 +31,37 @@ type Classifier interface {
 	Dimension() int
 }
 
+// Merger is implemented by classifiers that can combine what they learned
+// with another classifier of the same type, so that learning can be split
+// across workers or partitions of the data.
+type Merger interface {
+	Merge(Classifier) error
+}
+
+// Weigher is implemented by classifiers that weight terms, so that Explain
+// can report the weights.
+type Weigher interface {
+	Weight(string) (float64, bool)
+}
+
 type TfIdfClassifier struct {
 	Counts map[string]int
-	dirty  bool
-	total  int
-	terms  []string
-	hash   int64
-	lock   sync.RWMutex
+
+	// Weights are the frozen IDF weights of PROJECTION_HASHED, Base the
+	// total term count when they were first frozen and Origin a digest of
+	// the first frozen weights; see Freeze.
+	Weights map[string]float64
+	Base    int
+	Origin  int64
+
+	dirty bool
+	total int
+	terms []string
+	hash  int64
+	lock  sync.RWMutex
+
+	// projection is set from the schema; see PROJECTION_HASHED.
+	projection int
 }
 
 func NewTfIdfClassifier() Classifier {
@@ -55,16 +79,118 @@ func (c *TfIdfClassifier) Learn(term string) {
 	if term == "" {
 		return
 	}
+	c.lock.Lock()
 	c.dirty = true
 	c.Counts[term]++
+	c.lock.Unlock()
 	return
 }
 
+// Merge adds the term counts of o, which must be a *TfIdfClassifier, as
+// if the terms o learned had been learned by c.
+func (c *TfIdfClassifier) Merge(o Classifier) error {
+	other, ok := o.(*TfIdfClassifier)
+	if !ok {
+		return fmt.Errorf("can't merge %T into %T", o, c)
+	}
+
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for term, count := range other.Counts {
+		c.Counts[term] += count
+	}
+	c.dirty = true
+
+	// weights frozen from different data, e.g. from partitions learned
+	// separately, are refrozen from the merged counts
+	if c.Origin != 0 && other.Origin != 0 && c.Origin != other.Origin {
+		c.Weights, c.Base, c.Origin = nil, 0, 0
+	}
+	return nil
+}
+
+// Freeze fixes the weights of the terms learned so far. With
+// PROJECTION_HASHED, signatures use the frozen weights, so that learning
+// more terms later leaves the signatures of records made of known terms
+// unchanged; terms learned after the first freeze get the weight they have
+// when they are frozen, and unknown terms the weight of a term seen once
+// more than Base.
+func (c *TfIdfClassifier) Freeze() {
+	c.Clean()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.Weights == nil {
+		c.Weights = make(map[string]float64)
+	}
+	for term, count := range c.Counts {
+		if _, frozen := c.Weights[term]; !frozen {
+			c.Weights[term] = math.Log(float64(c.total) / float64(count))
+		}
+	}
+	if c.Base == 0 && c.total > 0 {
+		c.Base = c.total
+		c.Origin = c.weightsHash()
+	}
+}
+
+// weightsHash returns a digest of the frozen weights.
+func (c *TfIdfClassifier) weightsHash() int64 {
+	terms := make([]string, 0, len(c.Weights))
+	for term := range c.Weights {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	h := fnv.New64a()
+	for _, term := range terms {
+		fmt.Fprintf(h, "%q %x\n", term, math.Float64bits(c.Weights[term]))
+	}
+	return int64(h.Sum64())
+}
+
 func (c *TfIdfClassifier) weight(term string) float64 {
 	return math.Log(float64(c.total) / float64(c.Counts[term]))
 }
 
+// hashedWeight returns the weight of term with PROJECTION_HASHED, and
+// whether term was learned. Unknown terms weigh as much as a term seen
+// once more than the whole vocabulary was learned, above any learned term.
+func (c *TfIdfClassifier) hashedWeight(term string) (float64, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if w, frozen := c.Weights[term]; frozen {
+		return w, true
+	}
+	if c.Counts[term] > 0 {
+		return c.weight(term), true
+	}
+	base := c.Base
+	if base == 0 {
+		base = c.total
+	}
+	return math.Log(float64(base + 1)), false
+}
+
+// Weight returns the IDF weight of term, and whether term was learned.
+func (c *TfIdfClassifier) Weight(term string) (float64, bool) {
+	c.Clean()
+	if c.projection == PROJECTION_HASHED && term != "" {
+		return c.hashedWeight(term)
+	}
+	if c.Counts[term] == 0 {
+		return 0, false
+	}
+	return c.weight(term), true
+}
+
 func (c *TfIdfClassifier) Signature(term string, n int, r RandomProvider, offset int64) (s []float64, err error) {
+	if c.projection == PROJECTION_HASHED {
+		return c.hashedSignature(term, n, r, offset)
+	}
+
 	c.Clean()
 	termIndex := sort.SearchStrings(c.terms, term)
 	if termIndex == len(c.terms) {
@@ -82,13 +208,66 @@ func (c *TfIdfClassifier) Signature(term string, n int, r RandomProvider, offset
 	return
 }
 
+// RANDOM_MASK bounds hashed projection indexes to the 2^33 values of a
+// random store.
+const RANDOM_MASK = 1<<33 - 1
+
+// hashedSignature projects term onto random values picked by hashing the
+// term and field, rather than by the term's position in the vocabulary,
+// and weighs it with hashedWeight, so terms that were never learned can be
+// signed too. The empty term, which is never learned, adds nothing.
+func (c *TfIdfClassifier) hashedSignature(term string, n int, r RandomProvider, field int64) (s []float64, err error) {
+	if term == "" {
+		return make([]float64, n), nil
+	}
+
+	c.Clean()
+	termWeight, _ := c.hashedWeight(term)
+
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, field)
+	h.Write([]byte(term))
+	seed := h.Sum64()
+
+	for i := 0; i < n; i++ {
+		randIdx := int64(mix64(seed+uint64(i)*0x9e3779b97f4a7c15) & RANDOM_MASK)
+		s = append(s, termWeight*r.Get(randIdx))
+	}
+
+	return
+}
+
+// mix64 is the splitmix64 finalizer.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Clean rebuilds the sorted vocabulary and total after learning. It runs
+// on every signature, so the common case, nothing to rebuild, only takes
+// the read lock.
 func (c *TfIdfClassifier) Clean() {
-	if !c.dirty && len(c.terms) > 0 {
+	c.lock.RLock()
+	clean := c.isClean()
+	c.lock.RUnlock()
+	if clean {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.isClean() {
 		return
 	}
 
 	c.terms = make([]string, 0, len(c.Counts))
 	c.total = 0
+	c.hash = 0
+	c.dirty = false
 
 	for term, count := range c.Counts {
 		c.terms = append(c.terms, term)
@@ -99,6 +278,10 @@ func (c *TfIdfClassifier) Clean() {
 	return
 }
 
+func (c *TfIdfClassifier) isClean() bool {
+	return !c.dirty && c.terms != nil
+}
+
 func (c *TfIdfClassifier) genHash() {
 	h := fnv.New64a()
 	enc := gob.NewEncoder(h)
@@ -0,0 +1,194 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// _maxRs records the largest index requested.
+type _maxRs struct {
+	_lcg
+	max int64
+}
+
+func (r *_maxRs) Get(i int64) float64 {
+	if i > r.max {
+		r.max = i
+	}
+	return r._lcg.Get(i)
+}
+
+func learnTerms(s *Schema, names ...string) {
+	c := make(chan map[string]string, len(names))
+	for _, name := range names {
+		c <- map[string]string{"name": name}
+	}
+	close(c)
+	s.Learn(c)
+}
+
+func TestProjections(t *testing.T) {
+	apple := map[string]string{"name": "apple"}
+
+	for _, projection := range []int{PROJECTION_POSITIONAL, PROJECTION_HASHED} {
+		s := &Schema{
+			HashCount:  64,
+			Width:      4,
+			Projection: projection,
+			Fields:     []*Field{&Field{Attrs: []string{"name"}}}}
+		s.hydrate()
+		r := &_maxRs{}
+
+		learnTerms(s, "apple", "pear", "plum")
+		before, err := s.Sign(apple, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a new term that sorts first shifts every positional index
+		learnTerms(s, "aardvark", "apricot")
+		after, err := s.Sign(apple, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stable := reflect.DeepEqual(before, after)
+		if projection == PROJECTION_HASHED && !stable {
+			t.Errorf("hashed: signature changed: %v != %v", after, before)
+		}
+		if projection == PROJECTION_POSITIONAL && stable {
+			t.Errorf("positional: signature unchanged")
+		}
+		if r.max > RANDOM_MASK {
+			t.Errorf("random index %d out of range", r.max)
+		}
+	}
+}
+
+func TestHashedUnknownTerm(t *testing.T) {
+	s := &Schema{
+		HashCount:  64,
+		Width:      4,
+		Projection: PROJECTION_HASHED,
+		Fields:     []*Field{&Field{Attrs: []string{"name"}}, &Field{Attrs: []string{"animal"}}}}
+	s.hydrate()
+	learnTerms(s, "apple", "pear")
+
+	r := &_lcg{}
+	banana, err := s.Sign(map[string]string{"name": "banana", "animal": "dog"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kiwi, err := s.Sign(map[string]string{"name": "kiwi", "animal": "dog"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(banana, kiwi) {
+		t.Error("unknown terms share a signature")
+	}
+
+	c := s.Fields[0].Classifier.(*TfIdfClassifier)
+	w, known := c.Weight("kiwi")
+	if known || w <= 0 {
+		t.Errorf("unknown term: weight %f, known %v", w, known)
+	}
+
+	// an empty attribute adds nothing to the signature
+	sums, err := s.Fields[1].Signature(map[string]string{}, s.HashCount, r, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sums, make([]float64, s.HashCount)) {
+		t.Errorf("empty term: %v", sums)
+	}
+}
+
+func learnAttrs(s *Schema, records ...map[string]string) {
+	c := make(chan map[string]string, len(records))
+	for _, attrs := range records {
+		c <- attrs
+	}
+	close(c)
+	s.Learn(c)
+}
+
+// hashedUpdate returns a saved two-field schema with hashed projection,
+// reloaded, and records made of the terms it learned.
+func hashedUpdate(t *testing.T) (*Schema, []map[string]string) {
+	s := &Schema{
+		HashCount:  64,
+		Width:      4,
+		Projection: PROJECTION_HASHED,
+		Fields:     []*Field{&Field{Attrs: []string{"name"}}, &Field{Attrs: []string{"animal"}}}}
+	s.hydrate()
+	records := []map[string]string{
+		{"name": "apple", "animal": "dog"},
+		{"name": "pear", "animal": "dog"},
+		{"name": "plum", "animal": "cat"},
+		{"name": "apple", "animal": "cat"}}
+	learnAttrs(s, records...)
+	s.Freeze()
+
+	buf := &bytes.Buffer{}
+	if err := s.SaveFormat(buf, FORMAT_JSON); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Schema{}
+	if err := loaded.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	return loaded, records
+}
+
+func TestHashedUpdate(t *testing.T) {
+	s, records := hashedUpdate(t)
+	r := &_lcg{}
+
+	before := make([]*Signature, len(records))
+	for i, record := range records {
+		var err error
+		before[i], err = s.SignDetailed(record, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// schema -update: more of the known terms, in different proportions
+	// in each field, and new terms in both
+	learnAttrs(s,
+		map[string]string{"name": "apple", "animal": "bird"},
+		map[string]string{"name": "fig", "animal": "bird"},
+		map[string]string{"name": "fig", "animal": "dog"},
+		map[string]string{"name": "kiwi", "animal": "bird"})
+	s.Freeze()
+
+	for i, record := range records {
+		after, err := s.SignDetailed(record, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(after.Sums, before[i].Sums) {
+			t.Errorf("%v: signature changed after update", record)
+		}
+	}
+
+	c := s.Fields[0].Classifier.(*TfIdfClassifier)
+	if w, known := c.Weight("fig"); !known || w != c.Weights["fig"] {
+		t.Errorf("fig: weight %f, known %v", w, known)
+	}
+}
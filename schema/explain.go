@@ -0,0 +1,148 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+// Explanation tells why two records did or didn't collide.
+type Explanation struct {
+	// Chunks are the indices of the chunks in which a query for the first
+	// record finds the second, i.e. the buckets the records share or, with
+	// multi-probe, a probed neighbour of the first record's bucket holding
+	// the second; Matches is their count. Probed lists the chunks that
+	// were only found by probing.
+	Chunks  []int               `json:"chunks"`
+	Matches int                 `json:"matches"`
+	Probed  []int               `json:"probed,omitempty"`
+	Fields  []*FieldExplanation `json:"fields"`
+}
+
+type FieldExplanation struct {
+	Comment string   `json:"comment"`
+	Attrs   []string `json:"attrs"`
+
+	// TermsA and TermsB are the transformed terms of each record, with
+	// their weights if the classifier reports them.
+	TermsA []*TermWeight `json:"terms_a"`
+	TermsB []*TermWeight `json:"terms_b"`
+
+	// Contribution is the field's share of the projected sums behind the
+	// bits of the shared chunks, averaged over both records. Shares of
+	// all fields add up to 1; a negative share means the field pulled
+	// against the collision.
+	Contribution float64 `json:"contribution"`
+
+	// SumsA and SumsB are the field's projections (see Field.Signature).
+	SumsA []float64 `json:"-"`
+	SumsB []float64 `json:"-"`
+}
+
+type TermWeight struct {
+	Term   string  `json:"term"`
+	Weight float64 `json:"weight"`
+	Known  bool    `json:"known"`
+}
+
+func (d *Field) terms(record map[string]string) []*TermWeight {
+	w, weighs := d.Classifier.(Weigher)
+	var out []*TermWeight
+	for _, t := range d.pick(record) {
+		tw := &TermWeight{Term: t}
+		if weighs {
+			tw.Weight, tw.Known = w.Weight(t)
+		}
+		out = append(out, tw)
+	}
+	return out
+}
+
+// Explain compares the signatures of records a and b, as a query for a
+// probing up to probes neighbouring buckets per chunk (see Probes) would,
+// so its Matches agree with the query's Result.
+func (s *Schema) Explain(a, b map[string]string, r RandomProvider, probes int) (*Explanation, error) {
+	x := &Explanation{}
+	sumsA := make([]float64, s.HashCount)
+	sumsB := make([]float64, s.HashCount)
+
+	offsets := s.offsets()
+	for f, d := range s.Fields {
+		fx := &FieldExplanation{
+			Comment: d.Comment,
+			Attrs:   d.Attrs,
+			TermsA:  d.terms(a),
+			TermsB:  d.terms(b)}
+
+		var err error
+		fx.SumsA, err = d.Signature(a, s.HashCount, r, offsets[f])
+		if err != nil {
+			return nil, err
+		}
+		fx.SumsB, err = d.Signature(b, s.HashCount, r, offsets[f])
+		if err != nil {
+			return nil, err
+		}
+		for i := range sumsA {
+			sumsA[i] += fx.SumsA[i]
+			sumsB[i] += fx.SumsB[i]
+		}
+
+		x.Fields = append(x.Fields, fx)
+	}
+
+	sigA, sigB := s.signature(sumsA), s.signature(sumsB)
+	for i, value := range sigA.Chunks {
+		if value == sigB.Chunks[i] {
+			x.Chunks = append(x.Chunks, i)
+			continue
+		}
+		if probes <= 0 {
+			continue
+		}
+		for _, probe := range probeChunk(value, sigA.ChunkSums(i), probes)[1:] {
+			if probe == sigB.Chunks[i] {
+				x.Chunks = append(x.Chunks, i)
+				x.Probed = append(x.Probed, i)
+				break
+			}
+		}
+	}
+	x.Matches = len(x.Chunks)
+
+	for _, fx := range x.Fields {
+		fx.Contribution = (share(fx.SumsA, sumsA, x.Chunks, s.Width) +
+			share(fx.SumsB, sumsB, x.Chunks, s.Width)) / 2
+	}
+
+	return x, nil
+}
+
+// share returns the part of the total sums of the bits of chunks that
+// comes from field, counting sums in the direction of their bit.
+func share(field, total []float64, chunks []int, width int) float64 {
+	var part, whole float64
+	for _, i := range chunks {
+		for k := i * width; k < (i+1)*width; k++ {
+			if total[k] >= 0 {
+				part += field[k]
+				whole += total[k]
+			} else {
+				part -= field[k]
+				whole -= total[k]
+			}
+		}
+	}
+	if whole == 0 {
+		return 0
+	}
+	return part / whole
+}
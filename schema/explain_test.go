@@ -0,0 +1,129 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"math"
+	"testing"
+)
+
+// _lcg is a deterministic RandomProvider with values spread over [-1, 1).
+type _lcg struct{}
+
+func (r *_lcg) Get(i int64) float64 {
+	x := uint64(i)*6364136223846793005 + 1442695040888963407
+	return float64(x>>11)/float64(1<<52) - 1
+}
+
+func TestExplain(t *testing.T) {
+	s := &Schema{
+		HashCount: 64,
+		Width:     4,
+		Fields: []*Field{
+			&Field{Attrs: []string{"name"}, Transforms: []*TransformI{&TransformI{Name: "upcase"}}},
+			&Field{Attrs: []string{"animal"}}}}
+	s.hydrate()
+
+	c := make(chan map[string]string, 4)
+	c <- map[string]string{"name": "apple", "animal": "dog"}
+	c <- map[string]string{"name": "pear", "animal": "dog"}
+	c <- map[string]string{"name": "plum", "animal": "cat"}
+	c <- map[string]string{"name": "fig", "animal": "cat"}
+	close(c)
+	s.Learn(c)
+
+	r := &_lcg{}
+	a := map[string]string{"name": "apple", "animal": "dog"}
+	b := map[string]string{"name": "pear", "animal": "dog"}
+
+	x, err := s.Explain(a, a, r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Matches != s.SignatureLen() {
+		t.Errorf("identical records: %d matches", x.Matches)
+	}
+
+	x, err = s.Explain(a, b, r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigA, _ := s.Sign(a, r)
+	sigB, _ := s.Sign(b, r)
+	matches := 0
+	for i := range sigA {
+		if sigA[i] == sigB[i] {
+			matches++
+		}
+	}
+	if x.Matches != matches || len(x.Chunks) != matches {
+		t.Errorf("%d matches, want %d", x.Matches, matches)
+	}
+
+	if x.Fields[0].TermsA[0].Term != "APPLE" || !x.Fields[0].TermsA[0].Known {
+		t.Errorf("%v", x.Fields[0].TermsA[0])
+	}
+	if w := x.Fields[1].TermsB[0].Weight; math.Abs(w-math.Log(2)) > 1e-9 {
+		t.Errorf("weight of dog: %f", w)
+	}
+
+	if matches > 0 {
+		total := x.Fields[0].Contribution + x.Fields[1].Contribution
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("contributions add up to %f", total)
+		}
+	}
+}
+
+func TestExplainProbes(t *testing.T) {
+	s := &Schema{
+		HashCount: 64,
+		Width:     4,
+		Fields:    []*Field{&Field{Attrs: []string{"name"}}}}
+	s.hydrate()
+
+	c := make(chan map[string]string, 3)
+	c <- map[string]string{"name": "apple"}
+	c <- map[string]string{"name": "apples"}
+	c <- map[string]string{"name": "pear"}
+	close(c)
+	s.Learn(c)
+
+	r := &_lcg{}
+	a := map[string]string{"name": "apple"}
+	b := map[string]string{"name": "apples"}
+
+	ix := NewMemoryIndex(s).(*MemoryIndex)
+	ix.SetProbes(3)
+	if err := ix.Write(&Record{Id: 1, Attrs: b}, r); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Query(a, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := s.Explain(a, b, r, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || x.Matches != results[0].Matches {
+		t.Errorf("%d matches, query found %v", x.Matches, results)
+	}
+	exact, _ := s.Explain(a, b, r, 0)
+	if x.Matches != exact.Matches+len(x.Probed) {
+		t.Errorf("%d matches, %d exact and %d probed", x.Matches, exact.Matches, len(x.Probed))
+	}
+}
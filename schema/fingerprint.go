@@ -0,0 +1,84 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+// FINGERPRINT_SAMPLES random values are hashed into a fingerprint, which
+// tells random stores generated from different seeds apart.
+const FINGERPRINT_SAMPLES = 64
+
+// Fingerprinter is implemented by signers that can identify everything
+// their signatures depend on, so that indexes can refuse to be queried
+// with signatures that aren't comparable to the ones they hold.
+type Fingerprinter interface {
+	Fingerprint(RandomProvider) string
+}
+
+// Fingerprint identifies the schema parameters, fields, learned weights
+// and random store r. Frozen weights (see Freeze) are identified by the
+// first ones frozen, which later learning doesn't change, so a frozen
+// schema keeps its fingerprint through updates but not when it is learned
+// anew; other vocabularies are identified as a whole.
+func (s *Schema) Fingerprint(r RandomProvider) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %d %d\n", s.HashCount, s.Width, s.Projection)
+	for _, d := range s.Fields {
+		fmt.Fprintf(h, "%q\n", d.Attrs)
+		for _, t := range d.Transforms {
+			args, _ := json.Marshal(t.Arguments)
+			fmt.Fprintf(h, "%s %s\n", t.Name, args)
+		}
+
+		c, ok := d.Classifier.(*TfIdfClassifier)
+		if ok && s.Projection == PROJECTION_HASHED && c.Origin != 0 {
+			fmt.Fprintf(h, "%d %x\n", c.Base, c.Origin)
+		} else if c, ok := d.Classifier.(interface {
+			Hash() int64
+		}); ok {
+			fmt.Fprintf(h, "%x\n", c.Hash())
+		}
+	}
+
+	for i := uint64(0); i < FINGERPRINT_SAMPLES; i++ {
+		binary.Write(h, binary.LittleEndian, r.Get(int64(mix64(i)&RANDOM_MASK)))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// SignerFingerprint returns the fingerprint of s with r, or "" if s isn't
+// a Fingerprinter.
+func SignerFingerprint(s Signer, r RandomProvider) string {
+	if f, ok := s.(Fingerprinter); ok {
+		return f.Fingerprint(r)
+	}
+	return ""
+}
+
+// CheckFingerprint returns an error if an index built with fingerprint
+// stored can't be used with fingerprint current. Indexes built before
+// fingerprints were recorded, or by signers without one, can't be checked.
+func CheckFingerprint(stored, current string) error {
+	if stored == "" || current == "" || stored == current {
+		return nil
+	}
+	return fmt.Errorf("fingerprint mismatch: index was built with %s, not %s; "+
+		"check the schema file and random store", stored, current)
+}
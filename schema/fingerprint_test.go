@@ -0,0 +1,140 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	for _, projection := range []int{PROJECTION_POSITIONAL, PROJECTION_HASHED} {
+		s := &Schema{
+			HashCount:  64,
+			Width:      4,
+			Projection: projection,
+			Fields:     []*Field{&Field{Attrs: []string{"name"}}}}
+		s.hydrate()
+		learnTerms(s, "apple", "pear")
+
+		fp := s.Fingerprint(&_lcg{})
+		if fp != s.Fingerprint(&_lcg{}) {
+			t.Error("fingerprint isn't deterministic")
+		}
+		if fp == s.Fingerprint(&_rs{}) {
+			t.Error("fingerprint ignores the random store")
+		}
+
+		learnTerms(s, "plum")
+		learned := s.Fingerprint(&_lcg{})
+		if learned == fp {
+			t.Errorf("projection %d: fingerprint ignores the vocabulary", projection)
+		}
+
+		// updates of a frozen schema keep signatures, and its fingerprint
+		s.Freeze()
+		frozen := s.Fingerprint(&_lcg{})
+		learnTerms(s, "fig", "apple")
+		s.Freeze()
+		updated := s.Fingerprint(&_lcg{})
+		if projection == PROJECTION_HASHED && updated != frozen {
+			t.Error("hashed: fingerprint changed with an update")
+		}
+		if projection == PROJECTION_POSITIONAL && updated == frozen {
+			t.Error("positional: fingerprint ignores the vocabulary")
+		}
+	}
+}
+
+func TestHashedUpdateQuery(t *testing.T) {
+	s, records := hashedUpdate(t)
+	r := &_lcg{}
+	ix := NewMemoryIndex(s)
+	for i, record := range records {
+		if err := ix.Write(&Record{Id: uint32(i + 1), Attrs: record}, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// schema -update, then query the index built before it
+	learnAttrs(s,
+		map[string]string{"name": "fig", "animal": "bird"},
+		map[string]string{"name": "apple", "animal": "bird"})
+	s.Freeze()
+
+	results, err := ix.Query(records[0], r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(sort.Reverse(ByMatches(results)))
+	if results[0].Record.Id != 1 || results[0].Matches != s.SignatureLen() {
+		t.Errorf("%v", results[0])
+	}
+
+	// a schema learned anew from other data isn't compatible
+	relearned := s.shard()
+	learnAttrs(relearned, records[1:]...)
+	relearned.Freeze()
+	if relearned.Fingerprint(r) == s.Fingerprint(r) {
+		t.Error("relearned schema has the same fingerprint")
+	}
+}
+
+func TestMemoryIndexFingerprint(t *testing.T) {
+	s := &Schema{
+		HashCount: 64,
+		Width:     4,
+		Fields:    []*Field{&Field{Attrs: []string{"name"}}}}
+	s.hydrate()
+	learnTerms(s, "apple", "pear")
+	apple := map[string]string{"name": "apple"}
+
+	ix := NewMemoryIndex(s)
+	if err := ix.Write(&Record{Id: 1, Attrs: apple}, &_lcg{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Query(apple, &_rs{}); err == nil {
+		t.Error("queried with a different random store")
+	}
+
+	ix = NewMemoryIndex(s)
+	if err := ix.Write(&Record{Id: 1, Attrs: apple}, &_lcg{}); err != nil {
+		t.Fatal(err)
+	}
+	r := &_countRs{}
+	if _, err := ix.Query(apple, r); err != nil {
+		t.Error(err)
+	}
+
+	// the fingerprint is computed by the first query only
+	first := r.n
+	if _, err := ix.Query(apple, r); err != nil {
+		t.Error(err)
+	}
+	if r.n-first != first-FINGERPRINT_SAMPLES {
+		t.Errorf("first query read %d random values, second %d", first, r.n-first)
+	}
+}
+
+// _countRs counts the random values read.
+type _countRs struct {
+	_lcg
+	n int
+}
+
+func (r *_countRs) Get(i int64) float64 {
+	r.n++
+	return r._lcg.Get(i)
+}
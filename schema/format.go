@@ -0,0 +1,182 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+// Schema file formats. FORMAT_GOB is the original encoding of Save;
+// the JSON formats can be diffed, reviewed and read by other tools.
+const (
+	FORMAT_GOB       = "gob"
+	FORMAT_JSON      = "json"
+	FORMAT_JSON_GZIP = "json.gz"
+)
+
+// SCHEMA_VERSION is the version of the JSON schema format written by
+// SaveFormat. Load refuses files of later versions. Version 2 added the
+// frozen weights of hashed projection.
+const SCHEMA_VERSION = 2
+
+const CLASSIFIER_TFIDF = "tfidf"
+
+type schemaFile struct {
+	Version    int          `json:"version"`
+	HashCount  int          `json:"hash_count"`
+	Width      int          `json:"chunk_size"`
+	Projection int          `json:"projection"`
+	Fields     []*fieldFile `json:"fields"`
+}
+
+type fieldFile struct {
+	Comment    string          `json:"comment"`
+	Attrs      []string        `json:"attrs"`
+	Transforms []*TransformI   `json:"transforms"`
+	Classifier *classifierFile `json:"classifier"`
+}
+
+type classifierFile struct {
+	Type    string             `json:"type"`
+	Counts  map[string]int     `json:"counts"`
+	Weights map[string]float64 `json:"weights,omitempty"`
+	Base    int                `json:"base,omitempty"`
+	Origin  int64              `json:"origin,omitempty"`
+}
+
+// SaveFormat writes the schema, including the learned term counts, in
+// one of the FORMAT_* formats.
+func (s *Schema) SaveFormat(w io.Writer, format string) error {
+	switch format {
+	case FORMAT_GOB:
+		return s.Save(w)
+	case FORMAT_JSON:
+		return s.saveJSON(w)
+	case FORMAT_JSON_GZIP:
+		gz := gzip.NewWriter(w)
+		if err := s.saveJSON(gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return fmt.Errorf("unknown schema format: %s", format)
+}
+
+func (s *Schema) saveJSON(w io.Writer) error {
+	f := &schemaFile{
+		Version:    SCHEMA_VERSION,
+		HashCount:  s.HashCount,
+		Width:      s.Width,
+		Projection: s.Projection}
+
+	for _, d := range s.Fields {
+		c, ok := d.Classifier.(*TfIdfClassifier)
+		if !ok {
+			return fmt.Errorf("field %s: can't save classifier %T", d.Comment, d.Classifier)
+		}
+		f.Fields = append(f.Fields, &fieldFile{
+			Comment:    d.Comment,
+			Attrs:      d.Attrs,
+			Transforms: d.Transforms,
+			Classifier: &classifierFile{
+				Type:    CLASSIFIER_TFIDF,
+				Counts:  c.Counts,
+				Weights: c.Weights,
+				Base:    c.Base,
+				Origin:  c.Origin}})
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *Schema) loadJSON(r io.Reader) error {
+	f := &schemaFile{}
+	if err := json.NewDecoder(r).Decode(f); err != nil {
+		return err
+	}
+	if f.Version == 0 {
+		return fmt.Errorf("not a schema file: no version")
+	}
+	if f.Version > SCHEMA_VERSION {
+		return fmt.Errorf("unsupported schema version: %d", f.Version)
+	}
+
+	if f.Projection != PROJECTION_POSITIONAL && f.Projection != PROJECTION_HASHED {
+		return fmt.Errorf("unknown projection: %d", f.Projection)
+	}
+
+	s.HashCount = f.HashCount
+	s.Width = f.Width
+	s.Projection = f.Projection
+	s.Fields = nil
+	for _, ff := range f.Fields {
+		d := &Field{
+			Comment:    ff.Comment,
+			Attrs:      ff.Attrs,
+			Transforms: ff.Transforms}
+
+		if ff.Classifier != nil {
+			if ff.Classifier.Type != CLASSIFIER_TFIDF {
+				return fmt.Errorf("field %s: unknown classifier: %s", ff.Comment, ff.Classifier.Type)
+			}
+			c := NewTfIdfClassifier().(*TfIdfClassifier)
+			for term, count := range ff.Classifier.Counts {
+				c.Counts[term] = count
+			}
+			c.Weights = ff.Classifier.Weights
+			c.Base = ff.Classifier.Base
+			c.Origin = ff.Classifier.Origin
+			c.dirty = true
+			d.Classifier = c
+		}
+		s.Fields = append(s.Fields, d)
+	}
+	return nil
+}
+
+// detectFormat returns the format of the schema file read by r.
+func detectFormat(r *bufio.Reader) (string, error) {
+	magic, err := r.Peek(2)
+	if err != nil {
+		return "", err
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return FORMAT_JSON_GZIP, nil
+	}
+
+	for i := 1; ; i++ {
+		b, err := r.Peek(i)
+		if err != nil {
+			return "", err
+		}
+		c := rune(b[i-1])
+		if c == '{' {
+			return FORMAT_JSON, nil
+		}
+		if !unicode.IsSpace(c) {
+			return FORMAT_GOB, nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFormats(t *testing.T) {
+	schema.hydrate()
+	appleDog := map[string]string{"name": "apple", "animal": "dog"}
+	c := make(chan map[string]string, 2)
+	c <- appleDog
+	c <- map[string]string{"name": "pear", "animal": "cat"}
+	close(c)
+	schema.Learn(c)
+	r := &_lcg{}
+	sig1, err := schema.Sign(appleDog, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FORMAT_GOB, FORMAT_JSON, FORMAT_JSON_GZIP} {
+		buf := &bytes.Buffer{}
+		if err := schema.SaveFormat(buf, format); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		s2 := &Schema{}
+		if err := s2.Load(buf); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		sig2, err := s2.Sign(appleDog, r)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(sig1, sig2) {
+			t.Errorf("%s: signatures differ", format)
+		}
+		h1 := schema.Fields[0].Classifier.(*TfIdfClassifier).Hash()
+		h2 := s2.Fields[0].Classifier.(*TfIdfClassifier).Hash()
+		if h1 != h2 {
+			t.Errorf("%s: classifier hash %x != %x", format, h2, h1)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	s := &Schema{}
+	err := s.Load(strings.NewReader(`{"version": 99, "hash_count": 8, "chunk_size": 4}`))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("%v", err)
+	}
+
+	err = s.Load(strings.NewReader(schemaJs))
+	if err == nil {
+		t.Error("loaded a schema definition as a schema file")
+	}
+}
@@ -15,6 +15,7 @@
 package schema
 
 import (
+	"log"
 	"sort"
 	"sync"
 )
@@ -31,9 +32,37 @@ type Index interface {
 	Flush() error
 }
 
+// StopBucketer is implemented by indexes that stop collecting IDs for
+// buckets that grow past a maximum size. Such buckets come from values
+// shared by a large part of the data (e.g. an empty field) and are
+// skipped at query time.
+type StopBucketer interface {
+	StopBuckets() []Bucket
+}
+
+// Bucket identifies the set of records sharing value Value in signature
+// chunk Chunk.
+type Bucket struct {
+	Chunk int
+	Value uint32
+}
+
+type ByBucket []Bucket
+
+func (b ByBucket) Len() int { return len(b) }
+func (b ByBucket) Less(i, j int) bool {
+	return b[i].Chunk < b[j].Chunk || (b[i].Chunk == b[j].Chunk && b[i].Value < b[j].Value)
+}
+func (b ByBucket) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
 type Record struct {
 	Id    uint32
 	Attrs map[string]string
+
+	// Source and Seq locate the record in its input: Seq is the
+	// one-indexed position of the record within Source.
+	Source string
+	Seq    int
 }
 
 type Result struct {
@@ -44,9 +73,16 @@ type Result struct {
 type MemoryIndex struct {
 	signer      Signer
 	ids         [][][]uint32
+	stops       map[Bucket]int
+	maxBucket   int
+	probes      int
 	idsLock     sync.RWMutex
 	records     map[uint32]map[string]string
 	recordsLock sync.RWMutex
+	fingerprint string
+	fpLock      sync.Mutex
+	current     string
+	currentOnce sync.Once
 }
 
 func (ix *MemoryIndex) put(i, j int, id uint32) {
@@ -59,11 +95,81 @@ func (ix *MemoryIndex) put(i, j int, id uint32) {
 		ix.ids[i] = append(ix.ids[i], []uint32{})
 	}
 
-	ix.ids[i][j] = append(ix.ids[i][j], id)
+	b := Bucket{i, uint32(j)}
+	if _, stopped := ix.stops[b]; stopped {
+		ix.stops[b]++
+	} else {
+		ix.ids[i][j] = append(ix.ids[i][j], id)
+		if ix.maxBucket > 0 && len(ix.ids[i][j]) > ix.maxBucket {
+			ix.stops[b] = len(ix.ids[i][j])
+			ix.ids[i][j] = nil
+		}
+	}
 	ix.idsLock.Unlock()
 }
 
+// SetMaxBucket sets the number of IDs a bucket may hold before it becomes
+// a stop bucket. Zero means no limit.
+func (ix *MemoryIndex) SetMaxBucket(n int) {
+	ix.maxBucket = n
+}
+
+// SetProbes sets the number of neighbouring buckets Query looks up per
+// chunk besides the exact one. It has no effect unless the signer is a
+// Prober.
+func (ix *MemoryIndex) SetProbes(n int) {
+	ix.probes = n
+}
+
+// StopBuckets returns the buckets that have exceeded the maximum size.
+func (ix *MemoryIndex) StopBuckets() []Bucket {
+	ix.idsLock.RLock()
+	stops := make([]Bucket, 0, len(ix.stops))
+	for b, _ := range ix.stops {
+		stops = append(stops, b)
+	}
+	ix.idsLock.RUnlock()
+	sort.Sort(ByBucket(stops))
+	return stops
+}
+
+// BucketSize returns the number of IDs written to a bucket, including
+// those no longer kept because it is a stop bucket.
+func (ix *MemoryIndex) BucketSize(b Bucket) int {
+	ix.idsLock.RLock()
+	defer ix.idsLock.RUnlock()
+	if n, stopped := ix.stops[b]; stopped {
+		return n
+	}
+	if b.Chunk >= len(ix.ids) || int(b.Value) >= len(ix.ids[b.Chunk]) {
+		return 0
+	}
+	return len(ix.ids[b.Chunk][b.Value])
+}
+
+// Fingerprint returns the fingerprint of the signer and random store the
+// index was built with (see Fingerprinter).
+func (ix *MemoryIndex) Fingerprint() string {
+	ix.fpLock.Lock()
+	defer ix.fpLock.Unlock()
+	return ix.fingerprint
+}
+
+// signerFingerprint returns the fingerprint Query checks against: that of
+// the signer with the random store of the first query. Computing it reads
+// the random store and every transform argument, so it is done once.
+func (ix *MemoryIndex) signerFingerprint(r RandomProvider) string {
+	ix.currentOnce.Do(func() { ix.current = SignerFingerprint(ix.signer, r) })
+	return ix.current
+}
+
 func (ix *MemoryIndex) Write(record *Record, r RandomProvider) (err error) {
+	ix.fpLock.Lock()
+	if ix.fingerprint == "" {
+		ix.fingerprint = SignerFingerprint(ix.signer, r)
+	}
+	ix.fpLock.Unlock()
+
 	sigs, err := ix.signer.Sign(record.Attrs, r)
 	if err != nil {
 		return
@@ -90,26 +196,43 @@ func (c ByMatches) Less(i, j int) bool { return c[i].Matches < c[j].Matches }
 func (c ByMatches) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 
 func (ix *MemoryIndex) Query(record map[string]string, r RandomProvider) (results []Result, err error) {
-	sigs, err := ix.signer.Sign(record, r)
+	err = CheckFingerprint(ix.Fingerprint(), ix.signerFingerprint(r))
 	if err != nil {
 		return
 	}
 
+	probes, err := Probes(ix.signer, record, r, ix.probes)
+	if err != nil {
+		return
+	}
+
+	// a record sits in one bucket per chunk, so probing neighbouring
+	// buckets still counts at most one match per chunk
 	counter := make(map[uint32]int)
+	skipped := 0
 	ix.idsLock.RLock()
-	for i, sig := range sigs {
-		for _, id := range ix.ids[i][int(sig)] {
-			counter[id]++
+	for i, values := range probes {
+		for _, sig := range values {
+			if _, stopped := ix.stops[Bucket{i, sig}]; stopped {
+				skipped++
+				continue
+			}
+			for _, id := range ix.ids[i][int(sig)] {
+				counter[id]++
+			}
 		}
 	}
 	ix.idsLock.RUnlock()
+	if skipped > 0 {
+		log.Printf("query: skipped %d stop buckets\n", skipped)
+	}
 
 	for k, v := range counter {
 		ix.recordsLock.RLock()
 		attrs := ix.records[k]
 		ix.recordsLock.RUnlock()
 		results = append(results, Result{
-			&Record{k, attrs}, v})
+			&Record{Id: k, Attrs: attrs}, v})
 	}
 
 	sort.Sort(sort.Reverse(ByMatches(results)))
@@ -120,6 +243,7 @@ func (ix *MemoryIndex) Query(record map[string]string, r RandomProvider) (result
 func NewMemoryIndex(s Signer) Index {
 	ix := &MemoryIndex{signer: s}
 	ix.records = make(map[uint32]map[string]string)
+	ix.stops = make(map[Bucket]int)
 
 	ix.ids = make([][][]uint32, s.SignatureLen())
 	for i, _ := range ix.ids {
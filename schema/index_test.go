@@ -23,8 +23,8 @@ var sig1 = []uint32{0, 255, 104, 172, 138, 51, 132, 248}
 var sig2 = []uint32{0, 255, 104, 172, 138, 51, 232, 177}
 var sig3 = []uint32{0, 255, 104, 197, 20, 149, 132, 62}
 
-var rec1 = &Record{1, map[string]string{"first": "John", "last": "Doe"}}
-var rec2 = &Record{2, map[string]string{"first": "Jane", "last": "Roe"}}
+var rec1 = &Record{Id: 1, Attrs: map[string]string{"first": "John", "last": "Doe"}}
+var rec2 = &Record{Id: 2, Attrs: map[string]string{"first": "Jane", "last": "Roe"}}
 
 type _schema struct {
 	fixture []uint32
@@ -87,3 +87,37 @@ func TestMemoryIndex(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMemoryIndexStopBuckets(t *testing.T) {
+	s := &_schema{sig1}
+	ix := NewMemoryIndex(s).(*MemoryIndex)
+	ix.SetMaxBucket(1)
+	r := &_random{}
+
+	ix.Write(rec1, r)
+	s.fixture = sig2
+	ix.Write(rec2, r)
+
+	// rec1 and rec2 share the first six chunks
+	stops := ix.StopBuckets()
+	if len(stops) != 6 {
+		t.Fatalf("%v", stops)
+	}
+	if stops[1] != (Bucket{1, 255}) {
+		t.Errorf("%v", stops[1])
+	}
+	if ix.BucketSize(Bucket{1, 255}) != 2 {
+		t.Errorf("%d", ix.BucketSize(Bucket{1, 255}))
+	}
+
+	s.fixture = sig3
+	results, err := ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// only chunk 6 (132) is matched outside the stop buckets
+	if len(results) != 1 || results[0].Record.Id != 1 || results[0].Matches != 1 {
+		t.Errorf("%v", results)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Prober is implemented by signers that support multi-probe queries, which
+// look up neighbouring buckets besides the exact ones to raise recall
+// without more hash functions.
+type Prober interface {
+	// Probe returns, for each chunk of the record's signature, the exact
+	// chunk value followed by up to n alternative values, most likely
+	// first.
+	Probe(map[string]string, RandomProvider, int) ([][]uint32, error)
+}
+
+// Probes returns the buckets to look up for record: for each chunk, the
+// exact value and, if s is a Prober, up to n neighbouring values.
+func Probes(s Signer, record map[string]string, r RandomProvider, n int) ([][]uint32, error) {
+	if p, ok := s.(Prober); ok && n > 0 {
+		return p.Probe(record, r, n)
+	}
+
+	sigs, err := s.Sign(record, r)
+	if err != nil {
+		return nil, err
+	}
+	probes := make([][]uint32, len(sigs))
+	for i, sig := range sigs {
+		probes[i] = []uint32{sig}
+	}
+	return probes, nil
+}
+
+// probeChunk returns value followed by up to n values obtained by flipping
+// sets of its bits, in increasing order of the total magnitude of the
+// flipped bits' margins.
+func probeChunk(value uint32, margins []float64, n int) []uint32 {
+	out := []uint32{value}
+
+	bits := make([]int, len(margins))
+	for i := range bits {
+		bits[i] = i
+	}
+	sort.Sort(byMargin{bits, margins})
+	cost := make([]float64, len(bits))
+	for i, b := range bits {
+		cost[i] = math.Abs(margins[b])
+	}
+
+	// enumerate sets of bit ranks in order of cost: from a set whose
+	// highest rank is j, "shift" replaces j by j+1 and "expand" adds j+1,
+	// which reaches every set exactly once
+	h := &probeHeap{}
+	if len(bits) > 0 {
+		heap.Push(h, probeSet{[]int{0}, cost[0]})
+	}
+	for h.Len() > 0 && len(out) <= n {
+		set := heap.Pop(h).(probeSet)
+
+		flipped := value
+		for _, rank := range set.ranks {
+			flipped ^= 1 << uint(bits[rank])
+		}
+		out = append(out, flipped)
+
+		last := set.ranks[len(set.ranks)-1]
+		if last+1 < len(bits) {
+			shifted := append(append([]int{}, set.ranks[:len(set.ranks)-1]...), last+1)
+			heap.Push(h, probeSet{shifted, set.cost - cost[last] + cost[last+1]})
+			expanded := append(append([]int{}, set.ranks...), last+1)
+			heap.Push(h, probeSet{expanded, set.cost + cost[last+1]})
+		}
+	}
+	return out
+}
+
+type byMargin struct {
+	bits    []int
+	margins []float64
+}
+
+func (b byMargin) Len() int { return len(b.bits) }
+func (b byMargin) Less(i, j int) bool {
+	return math.Abs(b.margins[b.bits[i]]) < math.Abs(b.margins[b.bits[j]])
+}
+func (b byMargin) Swap(i, j int) { b.bits[i], b.bits[j] = b.bits[j], b.bits[i] }
+
+type probeSet struct {
+	ranks []int
+	cost  float64
+}
+
+type probeHeap []probeSet
+
+func (h probeHeap) Len() int            { return len(h) }
+func (h probeHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h probeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *probeHeap) Push(x interface{}) { *h = append(*h, x.(probeSet)) }
+func (h *probeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
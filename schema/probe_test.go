@@ -0,0 +1,106 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProbeChunk(t *testing.T) {
+	// bits 0 and 2 are set; bit 2 is the least confident, then bit 0
+	margins := []float64{0.5, -2.0, 0.1}
+
+	probes := probeChunk(5, margins, 3)
+	if !reflect.DeepEqual(probes, []uint32{5, 1, 4, 0}) {
+		t.Errorf("%v", probes)
+	}
+
+	// every other value is reached exactly once
+	probes = probeChunk(5, margins, 100)
+	if !reflect.DeepEqual(probes, []uint32{5, 1, 4, 0, 7, 3, 6, 2}) {
+		t.Errorf("%v", probes)
+	}
+}
+
+type _prober struct {
+	_schema
+	probes [][]uint32
+}
+
+func (s *_prober) Probe(_ map[string]string, _ RandomProvider, n int) ([][]uint32, error) {
+	return s.probes, nil
+}
+
+func TestMemoryIndexProbes(t *testing.T) {
+	s := &_prober{_schema: _schema{sig1}}
+	ix := NewMemoryIndex(s).(*MemoryIndex)
+	r := &_random{}
+
+	ix.Write(rec1, r)
+
+	s.fixture = sig3
+	s.probes = make([][]uint32, len(sig3))
+	for i := range sig3 {
+		s.probes[i] = []uint32{sig3[i]}
+		if sig1[i] != sig3[i] {
+			s.probes[i] = append(s.probes[i], sig1[i])
+		}
+	}
+
+	results, err := ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Matches != 4 {
+		t.Errorf("without probes: %d", results[0].Matches)
+	}
+
+	ix.SetProbes(1)
+	results, err = ix.Query(map[string]string{}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Matches != 8 {
+		t.Errorf("with probes: %d", results[0].Matches)
+	}
+}
+
+func TestSchemaProbe(t *testing.T) {
+	schema.hydrate()
+	appleDog := map[string]string{"name": "apple", "animal": "dog"}
+	c := make(chan map[string]string, 1)
+	c <- appleDog
+	close(c)
+	schema.Learn(c)
+
+	sigs, err := schema.Sign(appleDog, &_rs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probes, err := schema.Probe(appleDog, &_rs{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(probes) != len(sigs) {
+		t.Fatalf("%d chunks, want %d", len(probes), len(sigs))
+	}
+	for i, values := range probes {
+		if values[0] != sigs[i] || len(values) != 5 {
+			t.Errorf("chunk %d: %v", i, values)
+		}
+	}
+}
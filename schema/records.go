@@ -0,0 +1,96 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/gob"
+	"io"
+	"sort"
+)
+
+// RecordStore is implemented by stores of the records written to an
+// index, so that they can be read back, e.g. to reindex them with another
+// schema.
+type RecordStore interface {
+	// Records sends the stored records to c, skipping the first skip,
+	// and closes c. Records come in the same order on every call as long
+	// as the store isn't modified, so that an interrupted read can be
+	// resumed by count.
+	Records(skip int, c chan<- *Record) error
+}
+
+// Snapshot is a saved copy of the records of a MemoryIndex.
+type Snapshot struct {
+	// Attrs are the attributes of each record, by ID.
+	Attrs map[uint32]map[string]string
+}
+
+func (s *Snapshot) Records(skip int, c chan<- *Record) error {
+	sendRecords(s.Attrs, skip, c)
+	return nil
+}
+
+// LoadSnapshot reads a snapshot written by MemoryIndex.SaveSnapshot.
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	err := gob.NewDecoder(r).Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveSnapshot writes the records of the index. Signatures aren't saved:
+// they are computed again when the snapshot is loaded.
+func (ix *MemoryIndex) SaveSnapshot(w io.Writer) error {
+	ix.recordsLock.RLock()
+	defer ix.recordsLock.RUnlock()
+	return gob.NewEncoder(w).Encode(&Snapshot{ix.records})
+}
+
+func (ix *MemoryIndex) Records(skip int, c chan<- *Record) error {
+	ix.recordsLock.RLock()
+	records := make(map[uint32]map[string]string, len(ix.records))
+	for id, attrs := range ix.records {
+		records[id] = attrs
+	}
+	ix.recordsLock.RUnlock()
+
+	sendRecords(records, skip, c)
+	return nil
+}
+
+type byId []uint32
+
+func (ids byId) Len() int           { return len(ids) }
+func (ids byId) Less(i, j int) bool { return ids[i] < ids[j] }
+func (ids byId) Swap(i, j int)      { ids[i], ids[j] = ids[j], ids[i] }
+
+// sendRecords sends records to c in order of ID.
+func sendRecords(records map[uint32]map[string]string, skip int, c chan<- *Record) {
+	ids := make([]uint32, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Sort(byId(ids))
+
+	for i, id := range ids {
+		if i < skip {
+			continue
+		}
+		c <- &Record{Id: id, Attrs: records[id]}
+	}
+	close(c)
+}
@@ -0,0 +1,70 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func readRecords(store RecordStore, skip int) ([]*Record, error) {
+	c := make(chan *Record)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- store.Records(skip, c)
+	}()
+
+	var records []*Record
+	for record := range c {
+		records = append(records, record)
+	}
+	return records, <-errs
+}
+
+func TestSnapshot(t *testing.T) {
+	s := &_schema{sig1}
+	ix := NewMemoryIndex(s).(*MemoryIndex)
+	r := &_random{}
+	ix.Write(rec2, r)
+	ix.Write(rec1, r)
+
+	buf := &bytes.Buffer{}
+	if err := ix.SaveSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := LoadSnapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []RecordStore{ix, snapshot} {
+		records, err := readRecords(store, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[0].Id != 1 || !reflect.DeepEqual(records[1].Attrs, rec2.Attrs) {
+			t.Errorf("%v", records)
+		}
+
+		records, err = readRecords(store, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].Id != 2 {
+			t.Errorf("%v", records)
+		}
+	}
+}
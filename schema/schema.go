@@ -15,11 +15,16 @@
 package schema
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"runtime"
+	"sync"
 )
 
 type RandomProvider interface {
@@ -32,6 +37,12 @@ type Transform struct {
 	Name        string
 	Description string
 	Instance    func(map[string]interface{}) (TransformF, error)
+
+	// Embed, if not nil, returns the arguments with anything the
+	// transform reads from outside the schema definition, such as a
+	// file, copied in, so that saved schemas don't depend on it. It is
+	// applied once, when a schema definition is loaded.
+	Embed func(map[string]interface{}) (map[string]interface{}, error)
 }
 
 type TransformI struct {
@@ -41,13 +52,21 @@ type TransformI struct {
 }
 
 func (ti *TransformI) hydrate() (err error) {
-	for _, xf := range Transforms {
-		if ti.Name == xf.Name {
-			ti.Fn, err = xf.Instance(ti.Arguments)
-			return
-		}
+	xf := findTransform(ti.Name)
+	if xf == nil {
+		return fmt.Errorf("function not found: %s", ti.Name)
+	}
+	ti.Fn, err = xf.Instance(ti.Arguments)
+	return
+}
+
+func (ti *TransformI) embed() (err error) {
+	xf := findTransform(ti.Name)
+	if xf == nil || xf.Embed == nil {
+		// unknown functions are reported by Validate
+		return
 	}
-	err = fmt.Errorf("function not found: %s", ti.Name)
+	ti.Arguments, err = xf.Embed(ti.Arguments)
 	return
 }
 
@@ -64,17 +83,33 @@ func (d *Field) hydrate() (err error) {
 	}
 	for _, t := range d.Transforms {
 		err = t.hydrate()
+		if err != nil {
+			return
+		}
 	}
 	return
 }
 
+func (d *Field) embed() error {
+	for j, t := range d.Transforms {
+		if err := t.embed(); err != nil {
+			return fmt.Errorf("transform %d (%s): %s", j, t.Name, err)
+		}
+	}
+	return nil
+}
+
 func (d *Field) Load(data []byte) (err error) {
 	err = json.Unmarshal(data, &d)
 	if err != nil {
 		return
 	}
 
-	d.hydrate()
+	err = d.embed()
+	if err != nil {
+		return
+	}
+	err = d.hydrate()
 
 	return
 }
@@ -101,6 +136,24 @@ func (d *Field) Learn(record map[string]string) {
 	}
 }
 
+// compatible returns an error unless o picks and transforms the same
+// terms as d.
+func (d *Field) compatible(o *Field) error {
+	if !reflect.DeepEqual(d.Attrs, o.Attrs) {
+		return fmt.Errorf("attrs %v != %v", o.Attrs, d.Attrs)
+	}
+	if len(d.Transforms) != len(o.Transforms) {
+		return fmt.Errorf("%d transforms != %d", len(o.Transforms), len(d.Transforms))
+	}
+	for i, t := range d.Transforms {
+		u := o.Transforms[i]
+		if t.Name != u.Name || !reflect.DeepEqual(t.Arguments, u.Arguments) {
+			return fmt.Errorf("transform %d: %s != %s", i, u.Name, t.Name)
+		}
+	}
+	return nil
+}
+
 func (d *Field) Signature(record map[string]string, n int, r RandomProvider, offset int64) (s []float64, err error) {
 	sig := make([]float64, n)
 	for _, t := range d.pick(record) {
@@ -115,10 +168,24 @@ func (d *Field) Signature(record map[string]string, n int, r RandomProvider, off
 	return sig, nil
 }
 
+// Term projections. With PROJECTION_POSITIONAL, the original mapping, a
+// term's random vector depends on its position in the sorted vocabulary
+// and on the sizes of the preceding fields' vocabularies, so learning any
+// new term changes every signature. With PROJECTION_HASHED it depends only
+// on the term and its field's position in the schema, and its weight is
+// frozen when the schema is saved (see Freeze), so a saved schema can learn
+// new terms while the signatures of records made of known terms, and the
+// index entries made from them, stay the same.
+const (
+	PROJECTION_POSITIONAL = 0
+	PROJECTION_HASHED     = 1
+)
+
 type Schema struct {
-	HashCount int      `json:"hash_count"`
-	Width     int      `json:"chunk_size"`
-	Fields    []*Field `json:"fields"`
+	HashCount  int      `json:"hash_count"`
+	Width      int      `json:"chunk_size"`
+	Projection int      `json:"projection"`
+	Fields     []*Field `json:"fields"`
 }
 
 func (s *Schema) SignatureLen() int {
@@ -129,25 +196,84 @@ func (s *Schema) ChunkBits() int {
 	return s.Width
 }
 
+// LoadJSON loads a schema definition.
 func (s *Schema) LoadJSON(data []byte) (err error) {
 	err = json.Unmarshal(data, &s)
 	if err != nil {
 		return
 	}
-	s.hydrate()
+	err = s.embed()
+	if err != nil {
+		return
+	}
+	err = s.Validate()
+	if err != nil {
+		return
+	}
+	err = s.hydrate()
 	return
 }
 
-func (s *Schema) Hyd() {
-	s.hydrate()
+// Hyd prepares a schema built in code rather than loaded: it instances
+// the transforms and classifiers, and returns the first error.
+func (s *Schema) Hyd() error {
+	return s.hydrate()
+}
+
+func (s *Schema) hydrate() error {
+	for i, d := range s.Fields {
+		err := d.hydrate()
+		if err != nil {
+			return fmt.Errorf("field %d (%s): %s", i, d.Comment, err)
+		}
+		if c, ok := d.Classifier.(*TfIdfClassifier); ok {
+			c.projection = s.Projection
+		}
+	}
+	return nil
+}
+
+// embed applies the Embed step of every transform of a schema definition.
+func (s *Schema) embed() error {
+	for i, d := range s.Fields {
+		if err := d.embed(); err != nil {
+			return fmt.Errorf("field %d (%s): %s", i, d.Comment, err)
+		}
+	}
+	return nil
 }
 
-func (s *Schema) hydrate() {
+// Freeze fixes the weights of the terms learned so far, with
+// PROJECTION_HASHED; see TfIdfClassifier.Freeze. Schemas are frozen before
+// they are saved for use.
+func (s *Schema) Freeze() {
+	if s.Projection != PROJECTION_HASHED {
+		return
+	}
 	for _, d := range s.Fields {
-		d.hydrate()
+		if c, ok := d.Classifier.(*TfIdfClassifier); ok {
+			c.Freeze()
+		}
 	}
 }
 
+// offsets returns the offset passed to each field's classifier: where the
+// field's random vectors start with PROJECTION_POSITIONAL, and the field's
+// position, which salts the term hashes, with PROJECTION_HASHED.
+func (s *Schema) offsets() []int64 {
+	offsets := make([]int64, len(s.Fields))
+	o := int64(0)
+	for i, d := range s.Fields {
+		if s.Projection == PROJECTION_HASHED {
+			offsets[i] = int64(i)
+			continue
+		}
+		offsets[i] = o
+		o += int64(d.Classifier.Dimension() * s.HashCount)
+	}
+	return offsets
+}
+
 func (s *Schema) Learn(c chan map[string]string) {
 	for record := range c {
 		for _, d := range s.Fields {
@@ -156,44 +282,162 @@ func (s *Schema) Learn(c chan map[string]string) {
 	}
 }
 
+// LearnRecords learns the records of c on one worker per CPU. Each worker
+// learns into its own shard of the schema; the shards are merged when c is
+// drained.
 func (s *Schema) LearnRecords(c chan *Record) {
-	for record := range c {
-		for _, d := range s.Fields {
-			d.Learn(record.Attrs)
+	s.LearnParallel(c, runtime.GOMAXPROCS(0))
+}
+
+// LearnParallel is LearnRecords with n workers.
+func (s *Schema) LearnParallel(c chan *Record, n int) {
+	shards := make([]*Schema, n)
+	wait := sync.WaitGroup{}
+	for i := range shards {
+		shards[i] = s.shard()
+		wait.Add(1)
+		go func(shard *Schema) {
+			defer wait.Done()
+			for record := range c {
+				for _, d := range shard.Fields {
+					d.Learn(record.Attrs)
+				}
+			}
+		}(shards[i])
+	}
+	wait.Wait()
+
+	for _, shard := range shards {
+		// shards are made by s, so they are always compatible
+		if err := s.Merge(shard); err != nil {
+			panic(err)
 		}
 	}
 }
 
-func (s *Schema) Sign(record map[string]string, r RandomProvider) ([]uint32, error) {
-	var raw [][]float64
-	var signatures []uint32
-
-	o := int64(0)
+// shard returns a copy of s with the same fields and fresh classifiers.
+// The shard reuses the transform functions s already hydrated rather than
+// hydrating them again, since other shards may be running them.
+func (s *Schema) shard() *Schema {
+	shard := &Schema{HashCount: s.HashCount, Width: s.Width, Projection: s.Projection}
 	for _, d := range s.Fields {
-		sig, err := d.Signature(record, s.HashCount, r, o)
+		transforms := make([]*TransformI, len(d.Transforms))
+		for i, t := range d.Transforms {
+			transforms[i] = &TransformI{Name: t.Name, Arguments: t.Arguments, Fn: t.Fn}
+		}
+		c := NewTfIdfClassifier().(*TfIdfClassifier)
+		c.projection = s.Projection
+		shard.Fields = append(shard.Fields, &Field{
+			Comment:    d.Comment,
+			Attrs:      d.Attrs,
+			Transforms: transforms,
+			Classifier: c})
+	}
+	return shard
+}
+
+// Merge adds what o learned to s. Both schemas must have the same
+// parameters and fields, i.e. come from the same schema definition.
+func (s *Schema) Merge(o *Schema) error {
+	if s.HashCount != o.HashCount || s.Width != o.Width {
+		return fmt.Errorf("can't merge schemas: hash count/chunk size %d/%d != %d/%d",
+			o.HashCount, o.Width, s.HashCount, s.Width)
+	}
+	if s.Projection != o.Projection {
+		return fmt.Errorf("can't merge schemas: projection %d != %d", o.Projection, s.Projection)
+	}
+	if len(s.Fields) != len(o.Fields) {
+		return fmt.Errorf("can't merge schemas: %d fields != %d", len(o.Fields), len(s.Fields))
+	}
+	for i, d := range s.Fields {
+		if err := d.compatible(o.Fields[i]); err != nil {
+			return fmt.Errorf("can't merge schemas: field %d: %s", i, err)
+		}
+	}
+
+	for i, d := range s.Fields {
+		m, ok := d.Classifier.(Merger)
+		if !ok {
+			return fmt.Errorf("can't merge schemas: field %d: can't merge %T", i, d.Classifier)
+		}
+		if err := m.Merge(o.Fields[i].Classifier); err != nil {
+			return fmt.Errorf("can't merge schemas: field %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Signature is a record's signature along with the projected sums its bits
+// were derived from: bit j of chunk i is set iff Sums[i*Width+j] >= 0, so
+// the magnitude of a sum tells how confidently its bit was set.
+type Signature struct {
+	Chunks []uint32
+	Sums   []float64
+	Width  int
+}
+
+// ChunkSums returns the projected sums of the bits of chunk i.
+func (sig *Signature) ChunkSums(i int) []float64 {
+	return sig.Sums[i*sig.Width : (i+1)*sig.Width]
+}
+
+// SignDetailed is like Sign, but also returns the sum over all fields of
+// the record's projections onto each of the HashCount random hyperplanes.
+func (s *Schema) SignDetailed(record map[string]string, r RandomProvider) (*Signature, error) {
+	sums := make([]float64, s.HashCount)
+
+	offsets := s.offsets()
+	for f, d := range s.Fields {
+		sig, err := d.Signature(record, s.HashCount, r, offsets[f])
 		if err != nil {
 			return nil, err
 		}
-		raw = append(raw, sig)
-		o += int64(d.Classifier.Dimension() * s.HashCount)
+		for i, v := range sig {
+			sums[i] += v
+		}
 	}
 
-	chunks := s.HashCount / s.Width
-	for i := 0; i < chunks; i++ {
+	return s.signature(sums), nil
+}
+
+func (s *Schema) signature(sums []float64) *Signature {
+	var chunks []uint32
+	for i := 0; i < s.HashCount/s.Width; i++ {
 		var chunk uint32
 		for j := 0; j < s.Width; j++ {
-			sum := 0.0
-			for _, v := range raw {
-				sum += v[(i*s.Width)+j]
-			}
-			if sum >= 0.0 {
+			if sums[(i*s.Width)+j] >= 0.0 {
 				chunk |= (1 << uint(j))
 			}
 		}
 
-		signatures = append(signatures, chunk)
+		chunks = append(chunks, chunk)
+	}
+
+	return &Signature{Chunks: chunks, Sums: sums, Width: s.Width}
+}
+
+func (s *Schema) Sign(record map[string]string, r RandomProvider) ([]uint32, error) {
+	sig, err := s.SignDetailed(record, r)
+	if err != nil {
+		return nil, err
 	}
-	return signatures, nil
+	return sig.Chunks, nil
+}
+
+// Probe implements Prober. The alternatives for a chunk flip the bits
+// whose projected sums were closest to zero, i.e. those most likely to
+// differ for a similar record.
+func (s *Schema) Probe(record map[string]string, r RandomProvider, n int) ([][]uint32, error) {
+	sig, err := s.SignDetailed(record, r)
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make([][]uint32, len(sig.Chunks))
+	for i, value := range sig.Chunks {
+		probes[i] = probeChunk(value, sig.ChunkSums(i), n)
+	}
+	return probes, nil
 }
 
 func (s *Schema) Save(w io.Writer) (err error) {
@@ -202,9 +446,32 @@ func (s *Schema) Save(w io.Writer) (err error) {
 	return
 }
 
+// Load reads a schema file in any of the FORMAT_* formats.
 func (s *Schema) Load(r io.Reader) (err error) {
-	dec := gob.NewDecoder(r)
-	err = dec.Decode(s)
-	s.hydrate()
+	br := bufio.NewReader(r)
+	format, err := detectFormat(br)
+	if err != nil {
+		return
+	}
+
+	switch format {
+	case FORMAT_GOB:
+		dec := gob.NewDecoder(br)
+		err = dec.Decode(s)
+	case FORMAT_JSON:
+		err = s.loadJSON(br)
+	case FORMAT_JSON_GZIP:
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(br)
+		if err != nil {
+			return
+		}
+		defer gz.Close()
+		err = s.loadJSON(gz)
+	}
+	if err != nil {
+		return
+	}
+	err = s.hydrate()
 	return
 }
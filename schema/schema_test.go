@@ -109,3 +109,113 @@ type _rs struct{}
 func (rs *_rs) Get(i int64) float64 {
 	return 0.0
 }
+
+func TestSignDetailed(t *testing.T) {
+	schema.hydrate()
+	appleDog := map[string]string{"name": "apple", "animal": "dog"}
+	c := make(chan map[string]string, 1)
+	c <- appleDog
+	close(c)
+	schema.Learn(c)
+
+	sig, err := schema.SignDetailed(appleDog, &_rs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := schema.Sign(appleDog, &_rs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(sig.Chunks, sigs) {
+		t.Errorf("%v != %v", sig.Chunks, sigs)
+	}
+	if len(sig.Sums) != schema.HashCount {
+		t.Errorf("%d sums", len(sig.Sums))
+	}
+	for i, chunk := range sig.Chunks {
+		for j, v := range sig.ChunkSums(i) {
+			if (v >= 0) != (chunk&(1<<uint(j)) != 0) {
+				t.Errorf("chunk %d bit %d: sum %f", i, j, v)
+			}
+		}
+	}
+}
+
+func learnSchema(records []map[string]string, workers int) *Schema {
+	s := &Schema{
+		HashCount: 64,
+		Width:     4,
+		Fields: []*Field{
+			&Field{Attrs: []string{"name"}, Transforms: []*TransformI{&TransformI{Name: "upcase"}}},
+			&Field{Attrs: []string{"animal"}}}}
+	s.hydrate()
+
+	c := make(chan *Record, len(records))
+	for i, attrs := range records {
+		c <- &Record{Id: uint32(i), Attrs: attrs}
+	}
+	close(c)
+	s.LearnParallel(c, workers)
+	return s
+}
+
+var learnRecords = []map[string]string{
+	{"name": "apple", "animal": "dog"},
+	{"name": "pear", "animal": "dog"},
+	{"name": "plum", "animal": "cat"},
+	{"name": "apple", "animal": "cat"},
+	{"name": "fig", "animal": "bird"}}
+
+// manyRecords repeats learnRecords n times, so that the workers of
+// LearnParallel overlap; run with -race to check they share nothing.
+func manyRecords(n int) []map[string]string {
+	var records []map[string]string
+	for i := 0; i < n; i++ {
+		records = append(records, learnRecords...)
+	}
+	return records
+}
+
+func TestLearnParallel(t *testing.T) {
+	records := manyRecords(1000)
+	serial := learnSchema(records, 1)
+	parallel := learnSchema(records, 4)
+
+	for i, d := range serial.Fields {
+		c1 := d.Classifier.(*TfIdfClassifier)
+		c2 := parallel.Fields[i].Classifier.(*TfIdfClassifier)
+		if !reflect.DeepEqual(c1.Counts, c2.Counts) {
+			t.Errorf("field %d: %v != %v", i, c2.Counts, c1.Counts)
+		}
+		if c1.Hash() != c2.Hash() {
+			t.Errorf("field %d: hash %x != %x", i, c2.Hash(), c1.Hash())
+		}
+	}
+}
+
+func TestSchemaMerge(t *testing.T) {
+	a := learnSchema(learnRecords[:2], 1)
+	b := learnSchema(learnRecords[2:], 1)
+	all := learnSchema(learnRecords, 1)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range all.Fields {
+		c1 := d.Classifier.(*TfIdfClassifier)
+		c2 := a.Fields[i].Classifier.(*TfIdfClassifier)
+		if !reflect.DeepEqual(c1.Counts, c2.Counts) {
+			t.Errorf("field %d: %v != %v", i, c2.Counts, c1.Counts)
+		}
+	}
+
+	b.Fields[1].Attrs = []string{"color"}
+	if err := a.Merge(b); err == nil {
+		t.Error("merged schemas with different fields")
+	}
+	b.Width = 8
+	if err := a.Merge(b); err == nil {
+		t.Error("merged schemas with different chunk sizes")
+	}
+}
@@ -43,11 +43,12 @@ func (sf SourceFields) parse(fields []string) (attrs map[string]string) {
 }
 
 type FileSource struct {
-	Fields     SourceFields `json:"fields"`
-	IdColumn   int          `json:"id_column"`
-	Delimiter  string       `json:"delimiter"`
-	Glob       string       `json:"glob"`
-	Concurrent int          `json:"concurrent"`
+	Fields     SourceFields   `json:"fields"`
+	IdColumn   int            `json:"id_column"`
+	Delimiter  string         `json:"delimiter"`
+	Glob       string         `json:"glob"`
+	Concurrent int            `json:"concurrent"`
+	Skip       map[string]int `json:"-"`
 	paths      []string
 	c          chan *Record
 	wait       sync.WaitGroup
@@ -76,19 +77,26 @@ func (f *FileSource) read(path string) {
 	}
 	defer file.Close()
 
+	skip := f.Skip[path]
+	seq := 0
+
 	r := csv.NewReader(file)
 	r.Comma = rune(f.Delimiter[0])
 	for line, err := r.Read(); err != io.EOF; line, err = r.Read() {
 		if err != nil {
 			panic(err)
 		}
+		seq++
+		if seq <= skip {
+			continue
+		}
 		id, err := strconv.ParseUint(line[f.IdColumn-1], 10, 32)
 		if err != nil {
 			panic(err)
 		}
 		attrs := f.Fields.parse(line)
 
-		f.c <- &Record{uint32(id), attrs}
+		f.c <- &Record{Id: uint32(id), Attrs: attrs, Source: path, Seq: seq}
 	}
 }
 
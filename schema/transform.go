@@ -15,7 +15,9 @@
 package schema
 
 import (
+	"encoding/gob"
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -25,38 +27,42 @@ var Transforms = []*Transform{
 	xformSplit,
 	xformTrim,
 	xformKillAfter,
+	xformNormalize,
+	xformStripDiacritics,
+	xformTransliterate,
+	xformCaseFold,
+	xformStripPunctuation,
+	xformRegexReplace,
+	xformRegexExtract,
+	xformRegexFilter,
+	xformSynonyms,
+	xformTokenize,
+	xformStopwords,
+	xformUnique,
 }
 
 var xformSubstr = &Transform{
 	Name:        "substr",
-	Description: "(begin:int end:int) substring of a string",
+	Description: "(begin:int end:int) characters begin to end of a string; negative indices count from the end",
 	Instance:    xformSubstrF,
 }
 
 func xformSubstrF(args map[string]interface{}) (tf TransformF, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("invalid args")
-		}
-	}()
-
-	b, exists := args["begin"]
-	if !exists {
-		err = fmt.Errorf("begin is required")
+	begin, err := requiredIntArg(args, "begin")
+	if err != nil {
 		return
 	}
-	begin := fuckJSON(b)
-
-	e, exists := args["end"]
-	if !exists {
-		err = fmt.Errorf("end is required")
+	end, err := requiredIntArg(args, "end")
+	if err != nil {
 		return
 	}
-	end := fuckJSON(e)
+	if (begin < 0) == (end < 0) && end < begin {
+		return nil, fmt.Errorf("end %d is before begin %d", end, begin)
+	}
 
 	tf = func(input []string) []string {
 		for i, t := range input {
-			input[i] = t[begin:end]
+			input[i] = substr(t, begin, end)
 		}
 		return input
 	}
@@ -64,6 +70,31 @@ func xformSubstrF(args map[string]interface{}) (tf TransformF, err error) {
 	return
 }
 
+// substr returns the characters of s from begin up to end. Negative
+// indices count from the end of s, and indices out of range are clamped,
+// so short values yield shorter or empty terms.
+func substr(s string, begin, end int) string {
+	r := []rune(s)
+	clamp := func(i int) int {
+		if i < 0 {
+			i += len(r)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > len(r) {
+			return len(r)
+		}
+		return i
+	}
+
+	b, e := clamp(begin), clamp(end)
+	if e <= b {
+		return ""
+	}
+	return string(r[b:e])
+}
+
 var xformUpcase = &Transform{
 	Name:        "upcase",
 	Description: "make a string uppercase",
@@ -98,15 +129,35 @@ func xformTrimF(args map[string]interface{}) (tf TransformF, err error) {
 
 var xformSplit = &Transform{
 	Name:        "split",
-	Description: "split a term",
+	Description: "(particles:[]string attach:bool ignore_case:bool) split a name into words, attaching particles like DE or VAN to the following word",
 	Instance:    xformSplitF,
 }
 
 func xformSplitF(args map[string]interface{}) (tf TransformF, err error) {
+	p := defaultParticles
+	list, err := stringsArg(args, "particles")
+	if err != nil {
+		return
+	}
+	attach, err := boolArg(args, "attach", true)
+	if err != nil {
+		return
+	}
+	fold, err := boolArg(args, "ignore_case", false)
+	if err != nil {
+		return
+	}
+	if list != nil || !attach || fold {
+		if list == nil {
+			list = prefixList
+		}
+		p = newParticles(list, attach, fold)
+	}
+
 	tf = func(input []string) []string {
 		out := make([]string, 0, len(input)*2)
 		for _, t := range input {
-			out = append(out, normalizeNames(t)...)
+			out = append(out, p.split(t)...)
 		}
 		return out
 	}
@@ -128,14 +179,18 @@ func killafter(s string, sep string) string {
 }
 
 func xformKillAfterF(args map[string]interface{}) (tf TransformF, err error) {
-	sep, exists := args["sep"]
+	arg, exists := args["sep"]
 	if !exists {
 		return nil, fmt.Errorf("missing arg for killafter")
 	}
+	sep, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("sep must be a string")
+	}
 
 	tf = func(input []string) []string {
 		for i, t := range input {
-			input[i] = killafter(t, sep.(string))
+			input[i] = killafter(t, sep)
 		}
 		return input
 	}
@@ -149,9 +204,43 @@ func init() {
 	for _, p := range prefixList {
 		prefixSet[p] = true
 	}
+
+	// list arguments, as decoded from JSON, for gob schema files
+	gob.Register([]interface{}{})
+}
+
+// particles configures how names are split into words: particles are
+// attached to the word that follows them, unless attach is false, in
+// which case every word stands alone. With fold, particles match in any
+// case.
+type particles struct {
+	set    map[string]bool
+	attach bool
+	fold   bool
+}
+
+var defaultParticles = &particles{set: prefixSet, attach: true}
+
+func newParticles(list []string, attach, fold bool) *particles {
+	p := &particles{set: make(map[string]bool), attach: attach, fold: fold}
+	for _, particle := range list {
+		p.set[p.key(particle)] = true
+	}
+	return p
+}
+
+func (p *particles) key(s string) string {
+	if p.fold {
+		return strings.ToUpper(s)
+	}
+	return s
 }
 
 func normalizeNames(name string) []string {
+	return defaultParticles.split(name)
+}
+
+func (p *particles) split(name string) []string {
 	out := []string{}
 	sp := strings.Split(strings.Replace(strings.TrimSpace(name), "-", " ", -1), " ")
 	spt := make([]string, 0, len(sp))
@@ -162,10 +251,13 @@ func normalizeNames(name string) []string {
 		}
 		spt = append(spt, t)
 	}
+	if !p.attach {
+		return append(out, spt...)
+	}
 
 	start := 0
 	for i := 0; i < len(spt); i++ {
-		_, exists := prefixSet[spt[i]]
+		_, exists := p.set[p.key(spt[i])]
 		if !exists {
 			newS := strings.Join(spt[start:i+1], " ")
 			out = append(out, newS)
@@ -176,14 +268,54 @@ func normalizeNames(name string) []string {
 	return out
 }
 
-// find a less unsavory name for this
-func fuckJSON(d interface{}) int {
-	switch d.(type) {
+// intArg returns d as an int, accepting whole numbers decoded from JSON.
+func intArg(d interface{}) (int, bool) {
+	switch v := d.(type) {
 	case int:
-		return d.(int)
+		return v, true
 	case float64:
-		return int(d.(float64))
-	default:
-		panic("not an int")
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// requiredIntArg returns the integer argument name.
+func requiredIntArg(args map[string]interface{}, name string) (int, error) {
+	v, exists := args[name]
+	if !exists {
+		return 0, fmt.Errorf("%s is required", name)
+	}
+	i, ok := intArg(v)
+	if !ok {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return i, nil
+}
+
+// stringArg returns the string argument name, or def if it is absent.
+func stringArg(args map[string]interface{}, name, def string) (string, error) {
+	v, exists := args[name]
+	if !exists {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", name)
+	}
+	return s, nil
+}
+
+// boolArg returns the boolean argument name, or def if it is absent.
+func boolArg(args map[string]interface{}, name string, def bool) (bool, error) {
+	v, exists := args[name]
+	if !exists {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s must be true or false", name)
 	}
+	return b, nil
 }
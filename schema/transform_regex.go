@@ -0,0 +1,120 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// patternArg compiles the required pattern argument.
+func patternArg(args map[string]interface{}) (*regexp.Regexp, error) {
+	if _, exists := args["pattern"]; !exists {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	pattern, err := stringArg(args, "pattern", "")
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(pattern)
+}
+
+var xformRegexReplace = &Transform{
+	Name:        "regex_replace",
+	Description: "(pattern:string replacement:string) replace matches of pattern; replacement may refer to groups as $1",
+	Instance:    xformRegexReplaceF,
+}
+
+func xformRegexReplaceF(args map[string]interface{}) (tf TransformF, err error) {
+	re, err := patternArg(args)
+	if err != nil {
+		return
+	}
+	replacement, err := stringArg(args, "replacement", "")
+	if err != nil {
+		return
+	}
+
+	tf = func(input []string) []string {
+		for i, t := range input {
+			input[i] = re.ReplaceAllString(t, replacement)
+		}
+		return input
+	}
+	return
+}
+
+var xformRegexExtract = &Transform{
+	Name:        "regex_extract",
+	Description: "(pattern:string group:int) replace each term by the given group (default: the whole match) of every match of pattern",
+	Instance:    xformRegexExtractF,
+}
+
+func xformRegexExtractF(args map[string]interface{}) (tf TransformF, err error) {
+	re, err := patternArg(args)
+	if err != nil {
+		return
+	}
+	group := 0
+	if g, exists := args["group"]; exists {
+		var ok bool
+		group, ok = intArg(g)
+		if !ok {
+			return nil, fmt.Errorf("group must be an integer")
+		}
+	}
+	if group < 0 || group > re.NumSubexp() {
+		return nil, fmt.Errorf("pattern has no group %d", group)
+	}
+
+	tf = func(input []string) []string {
+		out := make([]string, 0, len(input))
+		for _, t := range input {
+			for _, m := range re.FindAllStringSubmatch(t, -1) {
+				out = append(out, m[group])
+			}
+		}
+		return out
+	}
+	return
+}
+
+var xformRegexFilter = &Transform{
+	Name:        "regex_filter",
+	Description: "(pattern:string invert:bool) keep only terms matching pattern, or with invert, only terms that don't",
+	Instance:    xformRegexFilterF,
+}
+
+func xformRegexFilterF(args map[string]interface{}) (tf TransformF, err error) {
+	re, err := patternArg(args)
+	if err != nil {
+		return
+	}
+	invert, err := boolArg(args, "invert", false)
+	if err != nil {
+		return
+	}
+
+	tf = func(input []string) []string {
+		out := input[:0]
+		for _, t := range input {
+			if re.MatchString(t) != invert {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	return
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var xformSynonyms = &Transform{
+	Name: "synonyms",
+	Description: "(file:string mode:string ignore_case:bool) map terms through a CSV dictionary of " +
+		"canonical,variant,... rows: to their canonical forms (mode canonical, the default) " +
+		"or to all their equivalents (mode expand)",
+	Instance: xformSynonymsF,
+	Embed:    embedSynonyms,
+}
+
+// synonyms is a parsed dictionary. Each group is a canonical form followed
+// by its variants; a term may belong to several groups.
+type synonyms struct {
+	groups     [][]string
+	membership map[string][]int
+	fold       bool
+}
+
+func (s *synonyms) key(term string) string {
+	if s.fold {
+		return strings.ToUpper(term)
+	}
+	return term
+}
+
+func parseSynonyms(r io.Reader, fold bool) (*synonyms, error) {
+	s := &synonyms{membership: make(map[string][]int), fold: fold}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		group := make([]string, 0, len(row))
+		for _, term := range row {
+			if term = strings.TrimSpace(term); term != "" {
+				group = append(group, term)
+			}
+		}
+		if len(group) < 2 {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: want canonical,variant", line)
+		}
+
+		for _, term := range group {
+			k := s.key(term)
+			s.membership[k] = append(s.membership[k], len(s.groups))
+		}
+		s.groups = append(s.groups, group)
+	}
+	return s, nil
+}
+
+// canonical returns the canonical forms of term, or term if it isn't in
+// the dictionary.
+func (s *synonyms) canonical(term string) []string {
+	groups := s.membership[s.key(term)]
+	if len(groups) == 0 {
+		return []string{term}
+	}
+	return s.collect(groups, func(group []string) []string { return group[:1] })
+}
+
+// expand returns term and all its equivalents.
+func (s *synonyms) expand(term string) []string {
+	groups := s.membership[s.key(term)]
+	if len(groups) == 0 {
+		return []string{term}
+	}
+	return s.collect(groups, func(group []string) []string { return group })
+}
+
+func (s *synonyms) collect(groups []int, pick func([]string) []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, g := range groups {
+		for _, term := range pick(s.groups[g]) {
+			if !seen[term] {
+				seen[term] = true
+				out = append(out, term)
+			}
+		}
+	}
+	return out
+}
+
+// embedSynonyms reads the dictionary file into the "dictionary" argument,
+// so that saved schemas carry the dictionary and map terms the same way
+// however the file changes later.
+func embedSynonyms(args map[string]interface{}) (map[string]interface{}, error) {
+	if _, embedded := args["dictionary"]; embedded {
+		return args, nil
+	}
+	dictionary, err := readDictionary(args)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded := make(map[string]interface{}, len(args)+1)
+	for k, v := range args {
+		embedded[k] = v
+	}
+	embedded["dictionary"] = dictionary
+	return embedded, nil
+}
+
+func readDictionary(args map[string]interface{}) (string, error) {
+	path, err := stringArg(args, "file", "")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", fmt.Errorf("file is required")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// xformSynonymsF uses the embedded dictionary if there is one (see
+// embedSynonyms), and otherwise reads the file.
+func xformSynonymsF(args map[string]interface{}) (tf TransformF, err error) {
+	dictionary, err := stringArg(args, "dictionary", "")
+	if err != nil {
+		return
+	}
+	if _, embedded := args["dictionary"]; !embedded {
+		dictionary, err = readDictionary(args)
+		if err != nil {
+			return
+		}
+	}
+
+	mode, err := stringArg(args, "mode", "canonical")
+	if err != nil {
+		return
+	}
+	fold, err := boolArg(args, "ignore_case", false)
+	if err != nil {
+		return
+	}
+
+	s, err := parseSynonyms(strings.NewReader(dictionary), fold)
+	if err != nil {
+		return nil, fmt.Errorf("dictionary: %s", err)
+	}
+
+	var lookup func(string) []string
+	switch mode {
+	case "canonical":
+		lookup = s.canonical
+	case "expand":
+		lookup = s.expand
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", mode)
+	}
+
+	tf = func(input []string) []string {
+		out := make([]string, 0, len(input))
+		for _, t := range input {
+			out = append(out, lookup(t)...)
+		}
+		return out
+	}
+	return
+}
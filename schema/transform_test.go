@@ -15,7 +15,11 @@
 package schema
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -110,4 +114,271 @@ func TestSplitNames(t *testing.T) {
 	}
 }
 
+func TestSplitParticles(t *testing.T) {
+	cases := []struct {
+		args map[string]interface{}
+		in   string
+		want []string
+	}{
+		{nil, "ROSADO DE GRACIA", []string{"ROSADO", "DE GRACIA"}},
+		{map[string]interface{}{"particles": []interface{}{"AL", "EL", "BIN"}}, "OMAR BIN AL FAROUK", []string{"OMAR", "BIN AL FAROUK"}},
+		{map[string]interface{}{"particles": []interface{}{"AL", "EL", "BIN"}}, "ROSADO DE GRACIA", []string{"ROSADO", "DE", "GRACIA"}},
+		{map[string]interface{}{"attach": false}, "VAN NUYS-CRUZ", []string{"VAN", "NUYS", "CRUZ"}},
+		{map[string]interface{}{"ignore_case": true}, "van der Berg", []string{"van der", "Berg"}},
+		{nil, "van der Berg", []string{"van", "der", "Berg"}},
+	}
+
+	for _, c := range cases {
+		out := applyTransform(t, xformSplit, c.args, c.in)
+		if !reflect.DeepEqual(out, c.want) {
+			t.Errorf("%v %q: %q != %q", c.args, c.in, out, c.want)
+		}
+	}
+}
+
+func TestSubstr(t *testing.T) {
+	cases := []struct {
+		begin, end interface{}
+		in         []string
+		want       []string
+	}{
+		{0.0, 3.0, []string{"APPLE", "AB", ""}, []string{"APP", "AB", ""}},
+		{0, 3, []string{"José", "Müller"}, []string{"Jos", "Mül"}},
+		{1.0, 4.0, []string{"Ñúñez"}, []string{"úñe"}},
+		{-3.0, 100.0, []string{"Müller", "AB"}, []string{"ler", "AB"}},
+		{0.0, -1.0, []string{"José", "J"}, []string{"Jos", ""}},
+		{5.0, 8.0, []string{"SMITH"}, []string{""}},
+	}
+
+	for _, c := range cases {
+		args := map[string]interface{}{"begin": c.begin, "end": c.end}
+		out := applyTransform(t, xformSubstr, args, c.in...)
+		if !reflect.DeepEqual(out, c.want) {
+			t.Errorf("%v %q: %q != %q", args, c.in, out, c.want)
+		}
+	}
+
+	bad := []map[string]interface{}{
+		{"end": 3.0},
+		{"begin": "0", "end": 3.0},
+		{"begin": 0.5, "end": 3.0},
+		{"begin": 3.0, "end": 1.0},
+		{"begin": -1.0, "end": -3.0},
+	}
+	for _, args := range bad {
+		if _, err := xformSubstr.Instance(args); err == nil {
+			t.Errorf("substr %v accepted", args)
+		}
+	}
+}
+
 // ugh todo another time: strip JR, SR, III, etc etc
+
+func applyTransform(t *testing.T, xf *Transform, args map[string]interface{}, input ...string) []string {
+	fn, err := xf.Instance(args)
+	if err != nil {
+		t.Fatalf("%s: %s", xf.Name, err)
+	}
+	return fn(input)
+}
+
+func TestUnicodeTransforms(t *testing.T) {
+	cases := []struct {
+		xf   *Transform
+		args map[string]interface{}
+		in   []string
+		want []string
+	}{
+		{xformNormalize, nil, []string{"ﬁ"}, []string{"fi"}},
+		{xformNormalize, map[string]interface{}{"form": "NFC"}, []string{"José"}, []string{"José"}},
+		{xformStripDiacritics, nil, []string{"José", "Müller", "Ñúñez"}, []string{"Jose", "Muller", "Nunez"}},
+		{xformTransliterate, nil, []string{"Łódź", "Straße", "Владимир", "Σωκράτης"}, []string{"Lodz", "Strasse", "Vladimir", "Sokratis"}},
+		{xformTransliterate, map[string]interface{}{"drop": true}, []string{"李Li"}, []string{"Li"}},
+		{xformTransliterate, nil, []string{"李Li"}, []string{"李Li"}},
+		{xformCaseFold, nil, []string{"Straße", "MÜLLER"}, []string{"strasse", "müller"}},
+		{xformStripPunctuation, nil, []string{"O'Brien", "St. John"}, []string{"OBrien", "St John"}},
+		{xformStripPunctuation, map[string]interface{}{"replacement": " "}, []string{"Smith-Jones"}, []string{"Smith Jones"}},
+	}
+
+	for _, c := range cases {
+		out := applyTransform(t, c.xf, c.args, c.in...)
+		if !reflect.DeepEqual(out, c.want) {
+			t.Errorf("%s %v: %q != %q", c.xf.Name, c.args, out, c.want)
+		}
+	}
+
+	if _, err := xformNormalize.Instance(map[string]interface{}{"form": "NFX"}); err == nil {
+		t.Error("unknown form accepted")
+	}
+}
+
+func TestRegexTransforms(t *testing.T) {
+	cases := []struct {
+		xf   *Transform
+		args map[string]interface{}
+		in   []string
+		want []string
+	}{
+		{xformRegexReplace, map[string]interface{}{"pattern": `\s+`, "replacement": " "}, []string{"ACME   CORP\tINC"}, []string{"ACME CORP INC"}},
+		{xformRegexReplace, map[string]interface{}{"pattern": `(\w+), (\w+)`, "replacement": "$2 $1"}, []string{"DOE, JOHN"}, []string{"JOHN DOE"}},
+		{xformRegexExtract, map[string]interface{}{"pattern": `\d+`}, []string{"12 MAIN ST APT 4", "NONE"}, []string{"12", "4"}},
+		{xformRegexExtract, map[string]interface{}{"pattern": `(\d{5})(-\d{4})?`, "group": 1.0}, []string{"NY 10001-1234"}, []string{"10001"}},
+		{xformRegexFilter, map[string]interface{}{"pattern": `^[A-Z]+$`}, []string{"JOHN", "J0HN", "DOE"}, []string{"JOHN", "DOE"}},
+		{xformRegexFilter, map[string]interface{}{"pattern": `^(MR|MRS|DR)$`, "invert": true}, []string{"DR", "JOHN"}, []string{"JOHN"}},
+	}
+
+	for _, c := range cases {
+		out := applyTransform(t, c.xf, c.args, c.in...)
+		if !reflect.DeepEqual(out, c.want) {
+			t.Errorf("%s %v: %q != %q", c.xf.Name, c.args, out, c.want)
+		}
+	}
+
+	bad := []struct {
+		xf   *Transform
+		args map[string]interface{}
+	}{
+		{xformRegexReplace, map[string]interface{}{}},
+		{xformRegexReplace, map[string]interface{}{"pattern": "("}},
+		{xformRegexExtract, map[string]interface{}{"pattern": "a", "group": 1}},
+		{xformRegexFilter, map[string]interface{}{"pattern": 1}},
+	}
+	for _, c := range bad {
+		if _, err := c.xf.Instance(c.args); err == nil {
+			t.Errorf("%s %v accepted", c.xf.Name, c.args)
+		}
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synonyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nicknames.csv")
+	dict := "# canonical,variant\nWILLIAM,BILL,WILL\nMARGARET,PEGGY\nWILFRED,WILL\n"
+	if err := ioutil.WriteFile(path, []byte(dict), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := applyTransform(t, xformSynonyms, map[string]interface{}{"file": path},
+		"BILL", "PEGGY", "WILL", "JOHN")
+	if !reflect.DeepEqual(out, []string{"WILLIAM", "MARGARET", "WILLIAM", "WILFRED", "JOHN"}) {
+		t.Errorf("canonical: %q", out)
+	}
+
+	out = applyTransform(t, xformSynonyms, map[string]interface{}{"file": path, "mode": "expand", "ignore_case": true},
+		"peggy")
+	if !reflect.DeepEqual(out, []string{"MARGARET", "PEGGY"}) {
+		t.Errorf("expand: %q", out)
+	}
+
+	args := map[string]interface{}{"file": path}
+	if _, err := xformSynonyms.Instance(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := args["dictionary"]; changed {
+		t.Error("Instance changed its arguments")
+	}
+
+	// the dictionary is saved with the schema
+	s := &Schema{}
+	err = s.LoadJSON([]byte(`{"hash_count": 8, "chunk_size": 4, "fields": [{"attrs": ["first"],
+		"transforms": [{"function": "synonyms", "arguments": {"file": "` + path + `"}}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := s.SaveFormat(buf, FORMAT_JSON); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+
+	s2 := &Schema{}
+	if err := s2.Load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := s2.Fields[0].xform("BILL"); !reflect.DeepEqual(out, []string{"WILLIAM"}) {
+		t.Errorf("reloaded: %q", out)
+	}
+
+	if _, err := xformSynonyms.Instance(map[string]interface{}{"dictionary": "WILLIAM\n"}); err == nil {
+		t.Error("row without variant accepted")
+	}
+}
+
+func TestTokenTransforms(t *testing.T) {
+	cases := []struct {
+		xf   *Transform
+		args map[string]interface{}
+		in   []string
+		want []string
+	}{
+		{xformTokenize, nil, []string{" ACME  WIDGET\tCO "}, []string{"ACME", "WIDGET", "CO"}},
+		{xformTokenize, map[string]interface{}{"separators": ",;"}, []string{"12 MAIN ST, APT 4;"}, []string{"12 MAIN ST", "APT 4"}},
+		{xformStopwords, map[string]interface{}{"words": []interface{}{"THE", "CO", "INC"}}, []string{"THE", "ACME", "CO"}, []string{"ACME"}},
+		{xformStopwords, map[string]interface{}{"words": []string{"the"}, "ignore_case": true}, []string{"The", "Acme"}, []string{"Acme"}},
+		{xformUnique, nil, []string{"NEW", "YORK", "NEW", "YORK", "CITY"}, []string{"NEW", "YORK", "CITY"}},
+	}
+
+	for _, c := range cases {
+		out := applyTransform(t, c.xf, c.args, c.in...)
+		if !reflect.DeepEqual(out, c.want) {
+			t.Errorf("%s %v: %q != %q", c.xf.Name, c.args, out, c.want)
+		}
+	}
+
+	if _, err := xformStopwords.Instance(map[string]interface{}{"words": "THE"}); err == nil {
+		t.Error("stopwords accepted a string")
+	}
+}
+
+func TestTokenTransformsPersist(t *testing.T) {
+	s := &Schema{}
+	err := s.LoadJSON([]byte(`{"hash_count": 8, "chunk_size": 4, "fields": [{"attrs": ["company"],
+		"transforms": [{"function": "tokenize"}, {"function": "stopwords", "arguments": {"words": ["THE", "CO"]}},
+		{"function": "unique"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FORMAT_GOB, FORMAT_JSON} {
+		buf := &bytes.Buffer{}
+		if err := s.SaveFormat(buf, format); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		s2 := &Schema{}
+		if err := s2.Load(buf); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		out := s2.Fields[0].xform("THE WIDGET CO ACME WIDGET")
+		if !reflect.DeepEqual(out, []string{"WIDGET", "ACME"}) {
+			t.Errorf("%s: %q", format, out)
+		}
+	}
+}
+
+func TestTokenBag(t *testing.T) {
+	s := &Schema{
+		HashCount: 64,
+		Width:     4,
+		Fields: []*Field{&Field{Attrs: []string{"company"},
+			Transforms: []*TransformI{&TransformI{Name: "tokenize"}, &TransformI{Name: "unique"}}}}}
+	s.hydrate()
+	learnAttrs(s,
+		map[string]string{"company": "ACME WIDGET CO"},
+		map[string]string{"company": "GLOBEX CO"},
+		map[string]string{"company": "INITECH"})
+
+	// a field's terms are signed as a bag, so their order doesn't matter
+	a := map[string]string{"company": "ACME WIDGET CO"}
+	b := map[string]string{"company": "WIDGET CO ACME WIDGET"}
+	x, err := s.Explain(a, b, &_lcg{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Matches != s.SignatureLen() {
+		t.Errorf("reordered tokens: %d of %d matches", x.Matches, s.SignatureLen())
+	}
+}
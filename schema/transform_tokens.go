@@ -0,0 +1,141 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// stringsArg returns the list of strings argument name, or nil if it is
+// absent.
+func stringsArg(args map[string]interface{}, name string) ([]string, error) {
+	v, exists := args[name]
+	if !exists {
+		return nil, nil
+	}
+	switch l := v.(type) {
+	case []string:
+		return l, nil
+	case []interface{}:
+		out := make([]string, 0, len(l))
+		for _, e := range l {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", name)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s must be a list of strings", name)
+}
+
+var xformTokenize = &Transform{
+	Name:        "tokenize",
+	Description: "(separators:string) split terms on any of the separator characters (default: whitespace)",
+	Instance:    xformTokenizeF,
+}
+
+func xformTokenizeF(args map[string]interface{}) (tf TransformF, err error) {
+	separators, err := stringArg(args, "separators", "")
+	if err != nil {
+		return
+	}
+
+	split := strings.Fields
+	if separators != "" {
+		split = func(s string) []string {
+			return strings.FieldsFunc(s, func(r rune) bool {
+				return strings.ContainsRune(separators, r)
+			})
+		}
+	}
+
+	tf = func(input []string) []string {
+		out := make([]string, 0, len(input)*2)
+		for _, t := range input {
+			for _, token := range split(t) {
+				if token = strings.TrimFunc(token, unicode.IsSpace); token != "" {
+					out = append(out, token)
+				}
+			}
+		}
+		return out
+	}
+	return
+}
+
+var xformStopwords = &Transform{
+	Name:        "stopwords",
+	Description: "(words:[]string ignore_case:bool) drop the given terms",
+	Instance:    xformStopwordsF,
+}
+
+func xformStopwordsF(args map[string]interface{}) (tf TransformF, err error) {
+	words, err := stringsArg(args, "words")
+	if err != nil {
+		return
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("words is required")
+	}
+	fold, err := boolArg(args, "ignore_case", false)
+	if err != nil {
+		return
+	}
+
+	key := func(s string) string { return s }
+	if fold {
+		key = strings.ToUpper
+	}
+	stop := make(map[string]bool, len(words))
+	for _, w := range words {
+		stop[key(w)] = true
+	}
+
+	tf = func(input []string) []string {
+		out := input[:0]
+		for _, t := range input {
+			if !stop[key(t)] {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	return
+}
+
+var xformUnique = &Transform{
+	Name:        "unique",
+	Description: "drop repeated terms, keeping the first of each",
+	Instance:    xformUniqueF,
+}
+
+func xformUniqueF(args map[string]interface{}) (tf TransformF, err error) {
+	tf = func(input []string) []string {
+		seen := make(map[string]bool, len(input))
+		out := input[:0]
+		for _, t := range input {
+			if !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	return
+}
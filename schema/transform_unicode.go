@@ -0,0 +1,199 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+var xformNormalize = &Transform{
+	Name:        "normalize",
+	Description: "(form:string) Unicode normalization to NFC, NFD, NFKC or NFKD (default)",
+	Instance:    xformNormalizeF,
+}
+
+var normForms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFD":  norm.NFD,
+	"NFKC": norm.NFKC,
+	"NFKD": norm.NFKD,
+}
+
+func xformNormalizeF(args map[string]interface{}) (tf TransformF, err error) {
+	name, err := stringArg(args, "form", "NFKD")
+	if err != nil {
+		return
+	}
+	form, exists := normForms[strings.ToUpper(name)]
+	if !exists {
+		return nil, fmt.Errorf("unknown form: %s", name)
+	}
+
+	tf = func(input []string) []string {
+		for i, t := range input {
+			input[i] = form.String(t)
+		}
+		return input
+	}
+	return
+}
+
+var xformStripDiacritics = &Transform{
+	Name:        "strip_diacritics",
+	Description: "remove accents and other combining marks, e.g. José to Jose",
+	Instance:    xformStripDiacriticsF,
+}
+
+// stripDiacritics decomposes s (NFKD), drops the combining marks and
+// recomposes what is left.
+func stripDiacritics(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+func xformStripDiacriticsF(args map[string]interface{}) (tf TransformF, err error) {
+	tf = func(input []string) []string {
+		for i, t := range input {
+			input[i] = stripDiacritics(t)
+		}
+		return input
+	}
+	return
+}
+
+var xformTransliterate = &Transform{
+	Name:        "transliterate",
+	Description: "(drop:bool) ASCII transliteration of Latin, Greek and Cyrillic letters; drop removes anything left that isn't ASCII",
+	Instance:    xformTransliterateF,
+}
+
+// translit maps letters that don't decompose into ASCII letters and marks.
+var translit = map[rune]string{
+	// Latin
+	'ß': "ss", 'ẞ': "SS", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe",
+	'Ø': "O", 'ø': "o", 'Đ': "D", 'đ': "d", 'Ð': "D", 'ð': "d",
+	'Ł': "L", 'ł': "l", 'Þ': "TH", 'þ': "th", 'ı': "i", 'Ħ': "H", 'ħ': "h",
+	'Ŋ': "NG", 'ŋ': "ng", 'ĸ': "q", 'Ŧ': "T", 'ŧ': "t",
+
+	// Greek
+	'Α': "A", 'Β': "V", 'Γ': "G", 'Δ': "D", 'Ε': "E", 'Ζ': "Z", 'Η': "I",
+	'Θ': "TH", 'Ι': "I", 'Κ': "K", 'Λ': "L", 'Μ': "M", 'Ν': "N", 'Ξ': "X",
+	'Ο': "O", 'Π': "P", 'Ρ': "R", 'Σ': "S", 'Τ': "T", 'Υ': "Y", 'Φ': "F",
+	'Χ': "CH", 'Ψ': "PS", 'Ω': "O",
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+
+	// Cyrillic
+	'А': "A", 'Б': "B", 'В': "V", 'Г': "G", 'Д': "D", 'Е': "E", 'Ё': "E",
+	'Ж': "ZH", 'З': "Z", 'И': "I", 'Й': "Y", 'К': "K", 'Л': "L", 'М': "M",
+	'Н': "N", 'О': "O", 'П': "P", 'Р': "R", 'С': "S", 'Т': "T", 'У': "U",
+	'Ф': "F", 'Х': "KH", 'Ц': "TS", 'Ч': "CH", 'Ш': "SH", 'Щ': "SHCH",
+	'Ъ': "", 'Ы': "Y", 'Ь': "", 'Э': "E", 'Ю': "YU", 'Я': "YA",
+	'Є': "YE", 'І': "I", 'Ї': "YI", 'Ґ': "G",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g",
+}
+
+func transliterate(s string, drop bool) string {
+	var b strings.Builder
+	for _, r := range stripDiacritics(s) {
+		if r <= unicode.MaxASCII {
+			b.WriteRune(r)
+		} else if t, exists := translit[r]; exists {
+			b.WriteString(t)
+		} else if !drop {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func xformTransliterateF(args map[string]interface{}) (tf TransformF, err error) {
+	drop, err := boolArg(args, "drop", false)
+	if err != nil {
+		return
+	}
+
+	tf = func(input []string) []string {
+		for i, t := range input {
+			input[i] = transliterate(t, drop)
+		}
+		return input
+	}
+	return
+}
+
+var xformCaseFold = &Transform{
+	Name:        "casefold",
+	Description: "Unicode case folding, for caseless comparison (e.g. Straße and STRASSE)",
+	Instance:    xformCaseFoldF,
+}
+
+func xformCaseFoldF(args map[string]interface{}) (tf TransformF, err error) {
+	tf = func(input []string) []string {
+		// cases.Caser isn't safe for concurrent use
+		fold := cases.Fold()
+		for i, t := range input {
+			input[i] = fold.String(t)
+		}
+		return input
+	}
+	return
+}
+
+var xformStripPunctuation = &Transform{
+	Name:        "strip_punctuation",
+	Description: "(replacement:string) replace punctuation, e.g. in O'Brien or Smith-Jones, with replacement (default: remove it)",
+	Instance:    xformStripPunctuationF,
+}
+
+func xformStripPunctuationF(args map[string]interface{}) (tf TransformF, err error) {
+	replacement, err := stringArg(args, "replacement", "")
+	if err != nil {
+		return
+	}
+
+	tf = func(input []string) []string {
+		for i, t := range input {
+			var b strings.Builder
+			for _, r := range t {
+				if unicode.IsPunct(r) {
+					b.WriteString(replacement)
+				} else {
+					b.WriteRune(r)
+				}
+			}
+			input[i] = b.String()
+		}
+		return input
+	}
+	return
+}
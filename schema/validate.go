@@ -0,0 +1,117 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MAX_CHUNK_BITS is the widest chunk a signature value can hold.
+const MAX_CHUNK_BITS = 32
+
+// ValidationError lists every problem found in a schema definition.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid schema: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// orNil returns e, or nil if it holds no problems.
+func (e *ValidationError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the schema definition, reporting all problems at once
+// in a *ValidationError.
+func (s *Schema) Validate() error {
+	e := &ValidationError{}
+
+	if s.HashCount <= 0 {
+		e.add("hash_count must be positive, not %d", s.HashCount)
+	}
+	if s.Width <= 0 || s.Width > MAX_CHUNK_BITS {
+		e.add("chunk_size must be between 1 and %d, not %d", MAX_CHUNK_BITS, s.Width)
+	} else if s.HashCount%s.Width != 0 {
+		e.add("hash_count %d is not a multiple of chunk_size %d", s.HashCount, s.Width)
+	}
+	if s.Projection != PROJECTION_POSITIONAL && s.Projection != PROJECTION_HASHED {
+		e.add("unknown projection: %d", s.Projection)
+	}
+	if len(s.Fields) == 0 {
+		e.add("no fields")
+	}
+
+	for i, d := range s.Fields {
+		for _, problem := range d.validate() {
+			e.add("field %d (%s): %s", i, d.Comment, problem)
+		}
+	}
+	return e.orNil()
+}
+
+func (d *Field) validate() (problems []string) {
+	if len(d.Attrs) == 0 {
+		problems = append(problems, "no attrs")
+	}
+	for j, t := range d.Transforms {
+		xf := findTransform(t.Name)
+		if xf == nil {
+			problems = append(problems, fmt.Sprintf("transform %d: unknown function %q", j, t.Name))
+			continue
+		}
+		_, err := xf.Instance(t.Arguments)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("transform %d (%s): %s", j, t.Name, err))
+		}
+	}
+	return
+}
+
+// ValidateSource checks that every attribute the schema uses is a field
+// of the source definition.
+func (s *Schema) ValidateSource(src SourceFields) error {
+	e := &ValidationError{}
+	names := make(map[string]bool)
+	for _, f := range src {
+		names[f.Name] = true
+	}
+	for i, d := range s.Fields {
+		for _, attr := range d.Attrs {
+			if !names[attr] {
+				e.add("field %d (%s): attr %q is not a source field", i, d.Comment, attr)
+			}
+		}
+	}
+	return e.orNil()
+}
+
+func findTransform(name string) *Transform {
+	for _, xf := range Transforms {
+		if xf.Name == name {
+			return xf
+		}
+	}
+	return nil
+}
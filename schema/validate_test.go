@@ -0,0 +1,67 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+)
+
+var badSchemaJs = `{"hash_count": 100, "chunk_size": 16, "fields": [
+	{"comment": "name", "attrs": ["name"], "transforms": [{"function": "upcsae"}, {"function": "substr", "arguments": {"begin": 0}}]},
+	{"comment": "city", "attrs": [], "transforms": [{"function": "killafter", "arguments": {"sep": 1}}]}]}`
+
+func TestValidate(t *testing.T) {
+	s := &Schema{}
+	err := s.LoadJSON([]byte(badSchemaJs))
+	e, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("%v", err)
+	}
+
+	want := []string{
+		"hash_count 100 is not a multiple of chunk_size 16",
+		"field 0 (name): transform 0: unknown function \"upcsae\"",
+		"field 0 (name): transform 1 (substr): end is required",
+		"field 1 (city): no attrs",
+		"field 1 (city): transform 0 (killafter): sep must be a string",
+	}
+	if len(e.Problems) != len(want) {
+		t.Fatalf("%q", e.Problems)
+	}
+	for i, problem := range want {
+		if e.Problems[i] != problem {
+			t.Errorf("%q != %q", e.Problems[i], problem)
+		}
+	}
+
+	s = &Schema{}
+	if err := s.LoadJSON([]byte(schemaJs)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	s := &Schema{}
+	if err := s.LoadJSON([]byte(schemaJs)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ValidateSource(SourceFields{{"name", 1}}); err != nil {
+		t.Error(err)
+	}
+	if err := s.ValidateSource(SourceFields{{"first_name", 1}}); err == nil {
+		t.Error("missing source field not reported")
+	}
+}
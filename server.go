@@ -0,0 +1,109 @@
+// Copyright 2014 William H. St. Clair
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/wsc/phosphorus/random"
+	"github.com/wsc/phosphorus/ratelimit"
+	"github.com/wsc/phosphorus/schema"
+	"log"
+	"net/http"
+	"time"
+)
+
+var cmdServer = &Command{
+	Run:       runServer,
+	UsageLine: "server",
+	Short:     "run the match server",
+}
+
+var (
+	serverDir      string
+	serverSchema   string // -schema flag
+	serverIndexDef string // -index flag
+	serverAddr     string // -addr flag
+	serverLimit    int    // -limit flag
+	serverRate     int    // -rate flag
+)
+
+func init() {
+	cmdServer.Flag.StringVar(&serverDir, "dir", "", "")
+	cmdServer.Flag.StringVar(&serverSchema, "schema", "", "")
+	cmdServer.Flag.StringVar(&serverIndexDef, "index", "", "")
+	cmdServer.Flag.StringVar(&serverAddr, "addr", ":8080", "")
+	cmdServer.Flag.IntVar(&serverLimit, "limit", 10, "")
+	cmdServer.Flag.IntVar(&serverRate, "rate", 0, "")
+}
+
+type queryHandler struct {
+	ix      schema.Index
+	rs      schema.RandomProvider
+	limiter *ratelimit.Limiter
+}
+
+// ServeHTTP answers a POST of a JSON object of attributes with a JSON
+// array of the best matches.
+func (h *queryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "POST a JSON record", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.limiter != nil {
+		err := h.limiter.Wait(req.Context(), 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	attrs := make(map[string]string)
+	err := json.NewDecoder(req.Body).Decode(&attrs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.ix.Query(attrs, h.rs)
+	if err != nil {
+		errMsg("query", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches(results, serverLimit))
+}
+
+func runServer(cmd *Command, args []string) {
+	rs := random.NewRandomStore(serverDir)
+	s := loadSchema(serverSchema)
+	ix := openIndex(loadIndexDef(serverIndexDef), s)
+
+	h := &queryHandler{ix: ix, rs: rs}
+	if serverRate > 0 {
+		// a fixed number of queries per second
+		h.limiter = ratelimit.New(ratelimit.Config{
+			Rate:     serverRate,
+			Interval: time.Second,
+			Increase: 1,
+			Decrease: 1})
+	}
+
+	http.Handle("/query", h)
+	msg("server", "listening on "+serverAddr)
+	log.Fatal(http.ListenAndServe(serverAddr, nil))
+}